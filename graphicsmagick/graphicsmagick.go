@@ -2,342 +2,4251 @@
 package graphicsmagick
 
 import (
+	"bytes"
 	"container/list"
+	"context"
 	"fmt"
+	"image"
 	"io/ioutil"
+	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pierrre/imageserver"
+	imageserver_source "github.com/pierrre/imageserver/source"
 )
 
 const (
 	param         = "graphicsmagick"
 	tempDirPrefix = "imageserver_"
+
+	maxStderrSize = 4096
+
+	defaultVignetteStrength = 50
+	defaultVignette         = "0x10"
+
+	defaultSharpen = "0x1"
+
+	radiusSigmaSeparators = "x,"
+
+	defaultSepiaThreshold = 80
+
+	defaultSolarizeThreshold = 50
+
+	defaultBorderColor = "dfdfdf"
+
+	maxDespeckle = 5
+
+	maxArtisticRadius = 20
+
+	maxOilPaintRadius = 10
+
+	defaultGravity = "Center"
+
+	defaultShearBackground = "ffffff"
+
+	defaultPadBackgroundJPEG = "ffffff"
+
+	defaultFlattenBackground = "ffffff"
+
+	pngAdaptiveFilter = 5
+
+	defaultInterlace = "Line"
+
+	minDPR = 0.5
+	maxDPR = 4
+
+	maxShearAngle = 89
+)
+
+var gravities = map[string]string{
+	"nw":        "NorthWest",
+	"northwest": "NorthWest",
+	"n":         "North",
+	"north":     "North",
+	"ne":        "NorthEast",
+	"northeast": "NorthEast",
+	"w":         "West",
+	"west":      "West",
+	"c":         "Center",
+	"center":    "Center",
+	"e":         "East",
+	"east":      "East",
+	"sw":        "SouthWest",
+	"southwest": "SouthWest",
+	"s":         "South",
+	"south":     "South",
+	"se":        "SouthEast",
+	"southeast": "SouthEast",
+}
+
+// transparentFormats are the source formats flattened by default for jpeg output.
+var transparentFormats = map[string]bool{
+	"png":  true,
+	"gif":  true,
+	"webp": true,
+}
+
+// coderFormats are the GraphicsMagick coders that write a variant of a format.
+var coderFormats = map[string]string{
+	"png8": "png",
+}
+
+// formatAliases are the built-in aliases for the "format" param.
+var formatAliases = map[string]string{
+	"jpg":  "jpeg",
+	"jpe":  "jpeg",
+	"tif":  "tiff",
+	"htm":  "html",
+	"svgz": "svg",
+}
+
+var lossyFormats = map[string]bool{
+	"jpeg": true,
+	"webp": true,
+}
+
+var interlaces = []string{"None", "Line", "Plane", "Partition"}
+
+// samplingFactors maps the chroma subsampling notation to the "-sampling-factor" HxV value.
+var samplingFactors = map[string]string{
+	"4:4:4": "1x1",
+	"4:2:2": "2x1",
+	"4:2:0": "2x2",
+}
+
+var colorspaces = []string{"RGB", "sRGB", "CMYK", "GRAY", "YCbCr"}
+
+var imageTypes = []string{"TrueColor", "Grayscale", "Palette", "Bilevel", "TrueColorMatte"}
+
+var levelNames = []string{"black point", "gamma", "white point"}
+
+var filterNames = []string{"point", "box", "triangle", "hermite", "hanning", "hamming", "blackman", "gaussian", "quadratic", "cubic", "catrom", "mitchell", "lanczos", "bessel", "sinc"}
+
+var filters = map[string]string{
+	"point":     "Point",
+	"box":       "Box",
+	"triangle":  "Triangle",
+	"hermite":   "Hermite",
+	"hanning":   "Hanning",
+	"hamming":   "Hamming",
+	"blackman":  "Blackman",
+	"gaussian":  "Gaussian",
+	"quadratic": "Quadratic",
+	"cubic":     "Cubic",
+	"catrom":    "Catrom",
+	"mitchell":  "Mitchell",
+	"lanczos":   "Lanczos",
+	"bessel":    "Bessel",
+	"sinc":      "Sinc",
+}
+
+var resizeModes = map[string]bool{
+	"resize":    true,
+	"thumbnail": true,
+	"sample":    true,
+	"scale":     true,
+}
+
+// fitMode is a "fit" param value.
+type fitMode struct {
+	modifier string // "-resize" geometry modifier
+	extent   bool   // "-extent" to the width/height
+}
+
+var fitModes = map[string]fitMode{
+	"cover":   {modifier: "^", extent: true},
+	"contain": {extent: true},
+	"fill":    {modifier: "!"},
+	"inside":  {},
+	"outside": {modifier: "^"},
+}
+
+var densityNames = []string{"x density", "y density"}
+
+var waveNames = []string{"amplitude", "wavelength"}
+
+var shearNames = []string{"x angle", "y angle"}
+
+var thresholdNames = []string{"threshold", "black_threshold", "white_threshold"}
+
+var contrastStretchNames = []string{"low", "high"}
+
+var sigmoidalContrastNames = []string{"contrast", "mid-point"}
+
+var (
+	modulateNames         = []string{"brightness", "saturation", "hue"}
+	modulateComponentsMax = []int{400, 400, 200}
+)
+
+// resourceLimitNames are the names of the ResourceLimits.Others resource limits.
+var resourceLimitNames = map[string]bool{
+	"file":   true,
+	"width":  true,
+	"height": true,
+	"read":   true,
+	"write":  true,
+}
+
+var imageMagickResourceLimitNames = map[string]bool{
+	"file":        true,
+	"height":      true,
+	"list-length": true,
+	"throttle":    true,
+	"time":        true,
+	"width":       true,
+}
+
+// profiles are the profiles removed one by one by "+profile", if some profiles must be kept.
+var profiles = []string{"8bim", "exif", "icc", "iptc", "xmp"}
+
+// profileAliases are the alternative names of the profiles.
+var profileAliases = map[string]string{
+	"icm": "icc",
+}
+
+// Backend is a command line backend.
+type Backend int
+
+const (
+	// GraphicsMagick runs the "gm" commands: "gm mogrify", "gm convert", ...
+	GraphicsMagick Backend = iota
+
+	// ImageMagick runs the ImageMagick 7 "magick" commands: "magick mogrify", "magick" (instead of "convert"), ...
+	ImageMagick
 )
 
+// ResourceLimits are the resource limits of the commands, added as "-limit <name> <value>" arguments.
+//
+// Values are a number, optionally followed by a unit: "K", "M", "G", "T", "P" or "E", and "B" or "iB" (e.g. "256MB", "10000000", "4").
+// An empty value is not limited.
+type ResourceLimits struct {
+	// Memory is the "memory" limit, the maximum heap memory.
+	Memory string
+
+	// Map is the "map" limit, the maximum memory mapped pixel cache.
+	Map string
+
+	// Disk is the "disk" limit, the maximum disk pixel cache.
+	Disk string
+
+	// Pixels is the "pixels" limit, the maximum number of pixels of an image ("area" for the ImageMagick Backend).
+	Pixels string
+
+	// Threads is the "threads" limit, the maximum number of threads ("thread" for the ImageMagick Backend).
+	Threads string
+
+	// Others is an optional map of other resource limits, by name.
+	// Names are: file, width, height, read, write.
+	// ImageMagick names are: file, height, list-length, throttle, time, width.
+	Others map[string]string
+}
+
 // Handler is a GraphicsMagick imageserver.Handler implementation.
 //
-// It processes the Image with the GraphicsMagick command line (mogrify command).
+// It processes the Image with the GraphicsMagick command line (mogrify command, or convert command if UseStdio is enabled).
+// The ImageMagick 7 command line can be used instead, see Backend.
 //
 // All params are extracted from the "graphicsmagick" node param and are optionals.
 //
+// The params that need the source Image dimensions (resize_percent, resize_area, extent, vignette, MaxWidth/MaxHeight/MaxPixels, ...) read them from the Image header with image.DecodeConfig,
+// so the decoders of the source formats must be registered by the application (e.g. import _ "image/jpeg").
+//
 // Params (see GraphicsMagick documentation for more information about arguments):
+//  - density: "-density" param, "<dpi>" or "<x_dpi>x<y_dpi>", greater than 0, set before the Image is read (useful for vector formats)
+//  - srgb: converts the Image to sRGB ("-profile <srgb.icc>", then "+profile icc" removes the embedded profile), runs an additional command before the main command, default ConvertToSRGB
+//  - auto_orient: "-auto-orient" param, applied first
+//  - coalesce: "-coalesce" param, applied before the other operations (it fully renders each frame of an animated Image), the frames are optimized again at the end if the output format is gif ("-deconstruct", or "-layers optimize" for the ImageMagick Backend)
+//  - trim: "-trim" param with "+repage", removes the borders of the same color, applied after coalesce
+//  - trim_fuzz: "-fuzz" param before "-trim" (reset after), color distance tolerance percentage between 0 and 100, requires trim
+//  - transpose: mirrors along the top-left to bottom-right diagonal ("-flip -rotate 90", or "-transpose" for the ImageMagick Backend), applied before resize
+//  - transverse: mirrors along the bottom-left to top-right diagonal ("-flop -rotate 90", or "-transverse" for the ImageMagick Backend), applied after transpose
+//  - gaussian_blur: "-gaussian" param ("-gaussian-blur" for the ImageMagick Backend), "<radius>x<sigma>", applied before resize
+//  - despeckle: "-despeckle" param, true or the number of repetitions (up to 5), requires AllowExpensiveFilters, applied before resize
+//  - median: "-median" param, odd radius between 1 and 9, requires AllowExpensiveFilters, applied before resize
+//  - crop: "-crop" param with "+repage", "<width>,<height>,<x>,<y>", each component is in pixels (multiplied by dpr) or a percentage of the Image dimensions ("25%", read from the Image header), applied before resize, can't be used with crop_ratio
+//  - crop_ratio: crops to an aspect ratio "<width>:<height>" (e.g. "16:9"), with "-crop" and "+repage" arguments, uses gravity (default "Center"), the source dimensions are read from the Image header (the EXIF orientation is ignored), applied before resize
+//  - crop_focus_x / crop_focus_y: focal point kept inside the crop window, percentages between 0 and 100 of the Image dimensions (default 50), the crop window is centered on it and clamped to the Image bounds (instead of using gravity), requires crop_ratio
 //  - width / height: sizes for "-resize" argument (both optionals)
+//  - dpr: device pixel ratio between 0.5 and 4, multiplies width / height (rounded to the nearest integer), capped to MaxDPR
 //  - fill: "^" for "-resize" argument
 //  - ignore_ratio: "!" for "-resize" argument
 //  - only_shrink_larger: ">" for "-resize" argument
 //  - only_enlarge_smaller: "<" for "-resize" argument
-//  - background: color for "-background" argument, 3/4/6/8 lower case hexadecimal characters
-//  - extent: "-extent" param, uses width/height params and add "-gravity center" argument
-//  - format: "-format" param
-//  - quality: "-quality" param
+//  - fit: high level resize mode, can't be used with fill, ignore_ratio, extent or pad:
+//    - cover: fills width x height ("^"), and crops the overflow with "-extent" (uses gravity), requires width and height
+//    - contain: fits inside width x height, and pads to width x height with "-extent" (uses gravity and background), requires width and height
+//    - fill: resizes to width x height exactly, ignoring the aspect ratio ("!"), requires width and height
+//    - inside: fits inside width x height, keeping the aspect ratio
+//    - outside: covers width x height ("^"), keeping the aspect ratio, without cropping
+//  - resize_percent: percentage for "-resize" argument, greater than 0 and up to 1000, can't be used with width / height
+//  - resize_area: maximum pixels count for "-resize" argument ("<area>@"), greater than 0, can't be used with width / height or resize_percent
+//  - resize_mode: resize operator, "resize" ("-resize", default), "thumbnail" ("-thumbnail", faster and removes the profiles and comments like strip), "sample" ("-sample") or "scale" ("-scale"), requires a resize param
+//  - filter: "-filter" param applied before the resize operator, "point", "box", "triangle", "hermite", "hanning", "hamming", "blackman", "gaussian", "quadratic", "cubic", "catrom", "mitchell", "lanczos", "bessel" or "sinc" (case insensitive), requires a resize param
+//  - rotate: "-rotate" param, degrees, normalized between 0 and 360 (e.g. -90 is 270), 0 is allowed (no rotation), the exposed corners are filled with the background color, applied after resize
+//  - rotate_expand: the canvas grows to fit the rotated Image (true, default), or it is cropped to the original size with "-crop" and "+repage" (false, the size is computed from the Image header), requires rotate
+//  - shave: "-shave" param, removes "<size>" or "<width>x<height>" pixels from each edge (0 is ignored), applied after resize and rotate
+//  - chop: "-chop" param, removes the "<width>x<height>[+<x>+<y>]" band (in pixels of the resized Image) and closes the gap, a width of 0 removes rows and a height of 0 removes columns, applied after shave
+//  - blur: "-blur" param, "<radius>x<sigma>", "<radius>,<sigma>" or "<sigma>" (radius 0), applied after resize
+//  - sharpen: "-sharpen" param, true (uses "0x1") or "<radius>x<sigma>", applied after resize
+//  - unsharp: "-unsharp" param, "<radius>x<sigma>[+<amount>[+<threshold>]]", amount between 0 and 5, applied after resize
+//  - type: "-type" param, TrueColor, Grayscale, Palette, Bilevel or TrueColorMatte (case insensitive), applied before colorspace and colors
+//  - colorspace: "-colorspace" param, RGB, sRGB, CMYK, GRAY or YCbCr (case insensitive)
+//  - normalize: "-normalize" param, can't be used with equalize (both true)
+//  - equalize: "-equalize" param
+//  - level: "-level" param, "<black_point>[,<gamma>[,<white_point>]]", points are percentages ("5%") or between 0 and 255, black_point must be lower than white_point, gamma between 0.1 and 10
+//  - contrast_stretch: "-contrast-stretch" param, "<low>" (symmetric), "<low>,<high>" or "<low>%x<high>%" clipping percentages, the sum must be less than 100, requires the ImageMagick Backend
+//  - modulate: "-modulate" param, "<brightness>[,<saturation>[,<hue>]]" percentages (default 100), brightness and saturation between 0 and 200
+//  - brightness / saturation / hue: "-modulate" param components, percentages (default 100), brightness and saturation between 0 and 400, hue between 0 and 200, can't be used with modulate
+//  - gamma: "-gamma" param, "<value>" or "<red>,<green>,<blue>", greater than 0
+//  - sigmoidal_contrast: "-sigmoidal-contrast" param, "<contrast>[,<mid-point>%]", contrast greater than 0, mid-point percentage between 0 and 100 (default 50), requires the ImageMagick Backend (GraphicsMagick doesn't support it)
+//  - posterize: "-posterize" param, number of levels between 2 and 255, applied after colorspace, requires the ImageMagick Backend
+//  - threshold / black_threshold / white_threshold: "-threshold" / "-black-threshold" / "-white-threshold" params, percentage between 0 and 100
+//  - negate: "-negate" param
+//  - negate_grays_only: "+negate" param, only negates the grayscale pixels, can't be used with negate
+//  - sepia: "-sepia-tone" param, threshold percentage between 0 and 100, true uses 80, requires the ImageMagick Backend
+//  - solarize: "-solarize" param, threshold percentage between 0 and 100, true uses 50
+//  - charcoal: "-charcoal" param, radius between 0 and 20, applied after resize
+//  - emboss: "-emboss" param, radius between 0 and 20, applied after resize
+//  - oil_paint: "-paint" param, radius between 1 and 10, requires AllowExpensiveFilters, applied after resize
+//  - swirl: "-swirl" param, degrees between -360 and 360, requires AllowDistortions
+//  - implode: "-implode" param, factor between -1 and 1, requires AllowDistortions
+//  - wave: "-wave" param, "<amplitude>,<wavelength>", greater than 0, requires AllowDistortions
+//  - roll: "-roll" param, "<+/-x><+/-y>" (e.g. "+10-20") or "<x>,<y>" offsets in pixels
+//  - background: color for "-background" argument, 3/4/6/8 lower case hexadecimal characters, applied before rotate (it is used by rotate, flatten, shear, extent and pad)
+//  - transparent: "-transparent" param, color made transparent, same format as background, applied before flatten
+//  - transparent_fuzz: "-fuzz" param before "-transparent" (reset after), color distance tolerance percentage between 0 and 100, requires transparent
+//  - flatten: "-flatten" param, flattens the layers and the transparency onto the background color (default white), enabled by default if the output format is jpeg and the source format is png, gif or webp (false disables it)
+//  - shear: "-shear" param, "<x_degrees>" or "<x_degrees>,<y_degrees>", between -89 and 89, the corners are filled with the background color (default white)
+//  - extent: "-extent" param, uses width/height params and add "-gravity" argument, requires width or height, if only one of width/height is set the other one is computed from the Image aspect ratio (read from the Image header, or the crop size)
+//  - pad: letterboxes the resized Image onto a width x height canvas, same as extent, the background color is transparent ("none") by default, or white for jpeg output, can't be used with extent
+//  - extent_x / extent_y: offsets in pixels added to the "-extent" geometry ("<width>x<height><+/-x><+/-y>"), requires extent or pad
+//  - gravity: "-gravity" param used by extent, "n", "ne", "e", "se", "s", "sw", "w", "nw", "c" or full names ("north", "NorthEast", ...), case insensitive, default "Center"
+//  - border: "-border" param, "<size>" or "<width>x<height>" in pixels (0 is ignored), applied after resize and extent
+//  - border_color: color for "-bordercolor" argument, same format as background (default "dfdfdf"), requires border
+//  - bordercolor: alias of border_color, can't be used with it
+//  - vignette: darkens the edges, with a blurred mask composited over the image (runs additional commands, after the main command, so it uses the resized dimensions), GraphicsMagick doesn't support "-vignette"; for the ImageMagick Backend: "-vignette" param applied after border, true (default "0x10") or "<radius>x<sigma>[+<x>+<y>]"
+//  - vignette_strength: vignette strength between 0 and 100 (default 50), requires vignette, not supported by the ImageMagick Backend
+//  - watermark: composites a watermark Image over the output Image (with the "composite" command), requires WatermarkServer, sub-params:
+//    - source: source of the watermark Image, given to WatermarkServer (required)
+//    - gravity: same as the "gravity" param, default "Center"
+//    - dissolve: opacity percentage between 0 and 100 (default 100)
+//    - x / y: offsets in pixels (default 0)
+//  - strip: "-strip" param, removes profiles and comments
+//  - keep_icc: keeps the ICC profile if strip is enabled (uses "+profile" for other profiles instead of "-strip")
+//  - keep_profiles: comma separated list of profiles kept if strip is enabled, "8bim", "exif", "icc" (or "icm"), "iptc" or "xmp" (uses "+profile" for other profiles instead of "-strip")
+//  - colors: "-colors" param, number of colors between 2 and 256, applied after posterize (it limits the final number of colors), can't be used with jpeg format
+//  - dither: "-dither" param (true) or "+dither" param (false), requires colors
+//  - alpha: output alpha channel, "keep" (default, no change), "remove" (flattens onto the background color like flatten, and removes the alpha channel) or "opaque" (removes the alpha channel with "+matte", or "-alpha off" for the ImageMagick Backend), applied before format
+//  - format: "-format" param, case insensitive, aliases are normalized (e.g. "jpg" is "jpeg", "tif" is "tiff", see FormatAliases)
+//  - png8: palette based png output (8 bits per pixel, up to 256 colors, "-format png8"), requires png output format, the Image format is still "png"
+//  - quality: "-quality" param, between 0 and 100 for lossy formats (jpeg, webp)
+//  - sampling_factor: jpeg chroma subsampling, "4:4:4", "4:2:2" or "4:2:0" ("-sampling-factor" param, "1x1", "2x1" or "2x2"), requires jpeg output format
+//  - max_bytes: maximum output size in bytes for jpeg output ("-define jpeg:extent=<n>KB", rounded down to a KB, at least 1024), the quality is lowered until the size fits (supported by the ImageMagick Backend, GraphicsMagick ignores it), ignored if the output format is not jpeg
+//  - png_compression: zlib compression level between 0 and 9, converted to the PNG "-quality" encoding ("<level>5", adaptive filtering, 0 is Huffman only) or "-define png:compression-level=<n>" for the ImageMagick Backend, requires png output format, can't be used with quality
+//  - webp_lossless: lossless webp encoding ("-define webp:lossless=true"), requires webp output format
+//  - webp_alpha_quality: alpha channel quality between 0 and 100 ("-define webp:alpha-quality=<n>"), requires webp output format
+//  - depth: "-depth" param, 8 or 16
+//  - interlace: "-interlace" param, "None", "Line", "Plane" or "Partition" (case insensitive), true uses "Line", false disables DefaultInterlace
 type Handler struct {
 	// Executable is the path to "gm" executable, usually "/usr/bin/gm".
+	// It is the path to "magick" executable for the ImageMagick Backend.
 	Executable string
 
-	// Timeoput is an optional timeout for process.
+	// Backend is the command line backend, GraphicsMagick by default.
+	Backend Backend
+
+	// Timeout is an optional timeout for process.
+	// It is applied on top of the context given to HandleContext.
 	Timeout time.Duration
 
 	// TempDir is an optional temp directory for image files.
 	TempDir string
 
-	// AllowedFormats is an optional list of allowed formats.
-	AllowedFormats []string
+	// KeepTempFiles keeps the temp directory (and the image files) after process, for debugging.
+	// It should be used with TempDirFunc, in order to know the path.
+	KeepTempFiles bool
+
+	// TempDirFunc is an optional function that is called with the temp directory path, after process.
+	// It is not called if UseStdio is enabled.
+	TempDirFunc func(dir string)
+
+	// ResourceLimits are optional resource limits, added as "-limit <name> <value>" arguments to all commands.
+	// They are validated before each command, an invalid limit is returned as an error.
+	ResourceLimits ResourceLimits
+
+	// MaxConcurrent is an optional maximum number of concurrent processings.
+	// If the limit is reached, the calls are blocked until a processing is finished, or the context is done.
+	MaxConcurrent int
+
+	// UseStdio uses the "convert" command with stdin/stdout instead of the "mogrify" command with a temp file.
+	// TempDir is not used.
+	// It is ignored for params that require several commands (vignette, watermark).
+	UseStdio bool
+
+	// WatermarkServer is an optional Server that returns the watermark Image for the "watermark" param.
+	WatermarkServer imageserver.Server
+
+	// AllowedFormats is an optional list of allowed formats (case insensitive).
+	// The "format" param is compared after the aliases normalization.
+	AllowedFormats []string
+
+	// FormatAliases is an optional map of aliases for the "format" param (e.g. "jpg": "jpeg").
+	// The keys are lower case, they override the built-in aliases.
+	FormatAliases map[string]string
+
+	// DefaultQuality is an optional quality per output format (e.g. "jpeg": 85, "webp": 80),
+	// used if the "quality" param is not set.
+	DefaultQuality map[string]int
+
+	// DefaultSamplingFactor is an optional jpeg chroma subsampling ("4:4:4", "4:2:2" or "4:2:0")
+	// used if the "sampling_factor" param is not set and the output format is jpeg.
+	DefaultSamplingFactor string
+
+	// SRGBProfile is an optional sRGB ICC profile data used by the "srgb" param.
+	// It is written to the temp directory for each conversion.
+	// If it is not set, the bundled sRGB profile is used.
+	SRGBProfile []byte
+
+	// ConvertToSRGB enables the sRGB conversion if the "srgb" param is not set.
+	ConvertToSRGB bool
+
+	// MinWidth and MinHeight are optional minimum values for width/height (multiplied by dpr) and the crop size (0 means no minimum).
+	MinWidth  int
+	MinHeight int
+
+	// MaxWidth, MaxHeight and MaxPixels are optional limits for the output size of resize, extent and crop (0 means unlimited).
+	// The resize output size is computed from the Image header (and dpr), a ParamError is returned if it exceeds them.
+	// An ImageError is returned if the Image header can't be decoded.
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+
+	// MaxDPR is an optional maximum value for the "dpr" param, higher values are capped.
+	MaxDPR float64
+
+	// DefaultFilter is an optional resize filter used if the "filter" param is not set (e.g. "lanczos").
+	DefaultFilter string
+
+	// DefaultDepth is an optional depth (8 or 16) used if the "depth" param is not set.
+	DefaultDepth int
+
+	// DefaultInterlace is an optional interlace ("None", "Line", "Plane" or "Partition", case insensitive)
+	// used if the "interlace" param is not set.
+	DefaultInterlace string
+
+	// MaxBlurSigma is an optional maximum sigma for the "blur" param.
+	MaxBlurSigma float64
+
+	// AllowExpensiveFilters allows the expensive filters params (despeckle, median, oil_paint).
+	AllowExpensiveFilters bool
+
+	// AllowDistortions allows the distortions params (swirl, implode, wave).
+	AllowDistortions bool
+
+	// AlwaysStrip applies "-strip" regardless of the "strip" param.
+	AlwaysStrip bool
+
+	// AutoOrientDefault applies "-auto-orient" if the "auto_orient" param is not set.
+	AutoOrientDefault bool
+
+	// Logger is an optional logger, used if the format detected from the output data
+	// is different from the expected format (the "format" param, or the source Image format).
+	// The detected format is used.
+	// If it is nil, the standard logger is used.
+	Logger *log.Logger
+
+	// CommandFunc is an optional function that is called after each command (e.g. for metrics),
+	// with its duration and error (nil if it succeeded).
+	CommandFunc func(duration time.Duration, err error)
+
+	semaphoreOnce sync.Once
+	semaphore     chan struct{}
+
+	version string
+}
+
+// Validate checks that Executable is GraphicsMagick, by running the "version" command.
+//
+// The detected version is returned by Version.
+// It should be called once, before Handle.
+func (hdr *Handler) Validate() error {
+	ctx := context.Background()
+	if hdr.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hdr.Timeout)
+		defer cancel()
+	}
+	versionArgument, versionProgram := "version", "GraphicsMagick"
+	if hdr.Backend == ImageMagick {
+		versionArgument, versionProgram = "-version", "ImageMagick"
+	}
+	out, err := exec.CommandContext(ctx, hdr.Executable, versionArgument).Output()
+	if err != nil {
+		return fmt.Errorf("%s executable %q: %s", versionProgram, hdr.Executable, err)
+	}
+	version, err := parseVersion(out, versionProgram)
+	if err != nil {
+		return fmt.Errorf("%s executable %q: %s", versionProgram, hdr.Executable, err)
+	}
+	hdr.version = version
+	return nil
+}
+
+// Version returns the GraphicsMagick version detected by Validate.
+//
+// It is empty if Validate was not called.
+func (hdr *Handler) Version() string {
+	return hdr.version
+}
+
+// parseVersion parses the output of the version command.
+//
+// The first line looks like "GraphicsMagick 1.3.35 2020-02-23 Q16 http://www.GraphicsMagick.org/",
+// or "Version: ImageMagick 7.0.10-0 Q16 x86_64 2020-03-14 https://imagemagick.org" for ImageMagick.
+func parseVersion(out []byte, program string) (string, error) {
+	line := string(out)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) > 0 && fields[0] == "Version:" {
+		fields = fields[1:]
+	}
+	if len(fields) < 2 || fields[0] != program {
+		return "", fmt.Errorf("not %s, unexpected version output %q", program, line)
+	}
+	return fields[1], nil
+}
+
+// Handle implements imageserver.Handler.
+func (hdr *Handler) Handle(im *imageserver.Image, params imageserver.Params) (*imageserver.Image, error) {
+	return hdr.HandleContext(context.Background(), im, params)
+}
+
+// HandleContext is like Handle, but it accepts a context.
+//
+// If the context is canceled, the running GraphicsMagick process is killed.
+//
+// If the "graphicsmagick" params are missing or empty, the Image is returned unchanged,
+// unless a server side option is set (AlwaysStrip, AutoOrientDefault, ConvertToSRGB or a Default* option).
+func (hdr *Handler) HandleContext(ctx context.Context, im *imageserver.Image, params imageserver.Params) (*imageserver.Image, error) {
+	if params.Has(param) {
+		var err error
+		params, err = params.GetParams(param)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		params = imageserver.Params{}
+	}
+	if params.Empty() && !hdr.hasServerOptions() {
+		return im, nil
+	}
+	im, err := hdr.handle(ctx, im, params)
+	if err != nil {
+		if err, ok := err.(*imageserver.ParamError); ok {
+			err.Param = param + "." + err.Param
+		}
+		return nil, err
+	}
+	return im, nil
+}
+
+// hasServerOptions returns true if an option applied without params is set.
+func (hdr *Handler) hasServerOptions() bool {
+	return hdr.AlwaysStrip ||
+		hdr.AutoOrientDefault ||
+		hdr.ConvertToSRGB ||
+		len(hdr.DefaultQuality) != 0 ||
+		hdr.DefaultSamplingFactor != "" ||
+		hdr.DefaultFilter != "" ||
+		hdr.DefaultDepth != 0 ||
+		hdr.DefaultInterlace != ""
+}
+
+func (hdr *Handler) handle(ctx context.Context, im *imageserver.Image, params imageserver.Params) (*imageserver.Image, error) {
+	arguments, format, formatSpecified, err := hdr.buildArguments(im, params)
+	if err != nil {
+		return nil, err
+	}
+	inputSteps, err := hdr.buildInputSteps(params)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := hdr.buildSteps(params)
+	if err != nil {
+		return nil, err
+	}
+	if arguments.Len() == 0 && len(inputSteps) == 0 && len(steps) == 0 {
+		return im, nil
+	}
+	if hdr.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hdr.Timeout)
+		defer cancel()
+	}
+	return hdr.process(ctx, im, arguments, inputSteps, steps, format, formatSpecified)
+}
+
+// step is an additional processing step, executed after the main command.
+//
+// It receives the temp directory and the output file, that it must update.
+// It can run other GraphicsMagick commands.
+//
+// An input step is executed before the main command, and receives the input file.
+type step func(ctx context.Context, tempDir string, file string) error
+
+func (hdr *Handler) buildInputSteps(params imageserver.Params) ([]step, error) {
+	var steps []step
+	srgb, err := hdr.buildStepSRGB(params)
+	if err != nil {
+		return nil, err
+	}
+	if srgb != nil {
+		steps = append(steps, srgb)
+	}
+	return steps, nil
+}
+
+func (hdr *Handler) buildSteps(params imageserver.Params) ([]step, error) {
+	var steps []step
+	vignette, err := hdr.buildStepVignette(params)
+	if err != nil {
+		return nil, err
+	}
+	if vignette != nil {
+		steps = append(steps, vignette)
+	}
+	watermark, err := hdr.buildStepWatermark(params)
+	if err != nil {
+		return nil, err
+	}
+	if watermark != nil {
+		steps = append(steps, watermark)
+	}
+	return steps, nil
+}
+
+// nolint: gocyclo
+func (hdr *Handler) buildArguments(im *imageserver.Image, params imageserver.Params) (arguments *list.List, format string, formatSpecified bool, err error) {
+	arguments = list.New()
+
+	err = hdr.buildArgumentsAutoOrient(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	coalesce, err := hdr.buildArgumentsCoalesce(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsTrim(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsTranspose(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsTransverse(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsGaussianBlur(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsDespeckle(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsMedian(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	cropWidth, cropHeight, err := hdr.buildArgumentsCrop(arguments, params, im)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	if cropWidth == 0 {
+		cropWidth, cropHeight, err = hdr.buildArgumentsCropRatio(arguments, params, im)
+		if err != nil {
+			return nil, "", false, err
+		}
+	}
+
+	width, height, geometry, err := hdr.buildArgumentsResize(arguments, params, im, cropWidth, cropHeight)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsBackground(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsRotate(arguments, params, im, geometry, cropWidth, cropHeight)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsShave(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsChop(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsBlur(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsSharpen(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsUnsharp(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsType(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsColorspace(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsNormalize(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsEqualize(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsLevel(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsContrastStretch(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsModulate(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsGamma(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsSigmoidalContrast(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsPosterize(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsThresholds(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsNegate(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsSepia(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsSolarize(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsCharcoal(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsEmboss(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsOilPaint(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsSwirl(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsImplode(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsWave(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsRoll(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsTransparent(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsFlatten(arguments, params, im)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsShear(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsExtent(arguments, params, im, width, height, cropWidth, cropHeight)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsBorder(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsVignette(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsStrip(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsColors(arguments, params, im)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsAlpha(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	format, coder, formatSpecified, err := hdr.buildArgumentsFormat(arguments, params, im)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsQuality(arguments, params, format)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsSamplingFactor(arguments, params, format)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsMaxBytes(arguments, params, format)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsPNGCompression(arguments, params, format)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsWebP(arguments, params, format)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsDepth(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	err = hdr.buildArgumentsInterlace(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	hdr.buildArgumentsOptimizeLayers(arguments, coalesce, format)
+
+	err = hdr.buildArgumentsDensity(arguments, params)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	return arguments, coder, formatSpecified, nil
+}
+
+func (hdr *Handler) process(ctx context.Context, im *imageserver.Image, arguments *list.List, inputSteps []step, steps []step, format string, formatSpecified bool) (*imageserver.Image, error) {
+	err := hdr.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer hdr.release()
+	var data []byte
+	if hdr.UseStdio && len(inputSteps) == 0 && len(steps) == 0 {
+		data, err = hdr.processStdio(ctx, im, arguments, format)
+	} else {
+		data, err = hdr.processTempFile(ctx, im, arguments, inputSteps, steps, format, formatSpecified)
+	}
+	if err != nil {
+		return nil, err
+	}
+	im = &imageserver.Image{
+		Format: hdr.detectFormat(data, coderFormat(format)),
+		Data:   data,
+	}
+	return im, nil
+}
+
+// formatSignatures are the magic numbers of the output formats detected by detectFormat ("?" matches any byte).
+var formatSignatures = []struct {
+	format    string
+	signature string
+}{
+	{"jpeg", "\xff\xd8\xff"},
+	{"png", "\x89PNG\r\n\x1a\n"},
+	{"gif", "GIF8?a"},
+	{"webp", "RIFF????WEBP"},
+	{"tiff", "II*\x00"},
+	{"tiff", "MM\x00*"},
+	{"bmp", "BM"},
+}
+
+// detectFormat returns the format detected from the data, by checking its magic number.
+//
+// If the format can't be detected, the expected format is returned.
+// If the detected format is different from the expected format, it is logged.
+func (hdr *Handler) detectFormat(data []byte, expected string) string {
+	detected := sniffFormat(data)
+	if detected == "" {
+		return expected
+	}
+	if detected != expected {
+		hdr.logf("GraphicsMagick output format mismatch: expected %s, detected %s", expected, detected)
+	}
+	return detected
+}
+
+// sniffFormat returns the format matching the magic number of the data, or an empty string.
+func sniffFormat(data []byte) string {
+	for _, fs := range formatSignatures {
+		if matchSignature(data, fs.signature) {
+			return fs.format
+		}
+	}
+	return ""
+}
+
+func matchSignature(data []byte, signature string) bool {
+	if len(data) < len(signature) {
+		return false
+	}
+	for i := 0; i < len(signature); i++ {
+		if signature[i] != '?' && signature[i] != data[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (hdr *Handler) logf(format string, v ...interface{}) {
+	if hdr.Logger != nil {
+		hdr.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// acquire waits for a slot if MaxConcurrent is set.
+func (hdr *Handler) acquire(ctx context.Context) error {
+	if hdr.MaxConcurrent <= 0 {
+		return nil
+	}
+	hdr.semaphoreOnce.Do(func() {
+		hdr.semaphore = make(chan struct{}, hdr.MaxConcurrent)
+	})
+	select {
+	case hdr.semaphore <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return &imageserver.ImageError{Message: fmt.Sprintf("GraphicsMagick concurrency limit: %s", ctx.Err())}
+	}
+}
+
+func (hdr *Handler) release() {
+	if hdr.MaxConcurrent <= 0 {
+		return
+	}
+	<-hdr.semaphore
+}
+
+func (hdr *Handler) processTempFile(ctx context.Context, im *imageserver.Image, arguments *list.List, inputSteps []step, steps []step, format string, formatSpecified bool) ([]byte, error) {
+	arguments.PushFront("mogrify")
+
+	tempDir, err := ioutil.TempDir(hdr.TempDir, tempDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if hdr.TempDirFunc != nil {
+			hdr.TempDirFunc(tempDir)
+		}
+		if !hdr.KeepTempFiles {
+			_ = os.RemoveAll(tempDir)
+		}
+	}()
+
+	file := filepath.Join(tempDir, "image")
+	arguments.PushBack(file)
+	err = ioutil.WriteFile(file, im.Data, os.FileMode(0600))
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range inputSteps {
+		err = st(ctx, tempDir, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = hdr.runArguments(ctx, convertArgumentsToSlice(arguments)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if formatSpecified {
+		file, err = getOutputFile(file, format)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, st := range steps {
+		err = st(ctx, tempDir, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.ReadFile(file)
+}
+
+// getOutputFile returns the file written by "mogrify -format".
+//
+// The extension is the coder name (e.g. "image.png8"), but some versions use the format name (e.g. "image.png"), so both are checked.
+func getOutputFile(file string, coder string) (string, error) {
+	output := fmt.Sprintf("%s.%s", file, coder)
+	format := coderFormat(coder)
+	if format == coder {
+		return output, nil
+	}
+	_, err := os.Stat(output)
+	if err == nil {
+		return output, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s", file, format), nil
+}
+
+// processStdio uses the "convert" command: the input Image is written to stdin, and the output Image is read from stdout.
+func (hdr *Handler) processStdio(ctx context.Context, im *imageserver.Image, arguments *list.List, format string) ([]byte, error) {
+	// "-format" is only used by "mogrify", "convert" gets the output format from the "<format>:-" output.
+	for e := arguments.Front(); e != nil; e = e.Next() {
+		if e.Value == "-format" && e.Next() != nil {
+			arguments.Remove(e.Next())
+			arguments.Remove(e)
+			break
+		}
+	}
+	// "-density" must be before the input.
+	if e := arguments.Front(); e != nil && e.Value == "-density" {
+		arguments.InsertAfter("-", e.Next())
+	} else {
+		arguments.PushFront("-")
+	}
+	arguments.PushFront("convert")
+	output := "-"
+	if format != "" {
+		output = format + ":-"
+	}
+	arguments.PushBack(output)
+
+	cmd, err := hdr.command(ctx, convertArgumentsToSlice(arguments))
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = bytes.NewReader(im.Data)
+	stdout := new(bytes.Buffer)
+	cmd.Stdout = stdout
+	err = hdr.runCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// buildArgumentsCoalesce must be called before the other operations, because "-coalesce" fully renders each frame of an animated Image.
+//
+// It returns true if the frames are coalesced, so they can be optimized again by buildArgumentsOptimizeLayers.
+func (hdr *Handler) buildArgumentsCoalesce(arguments *list.List, params imageserver.Params) (bool, error) {
+	if !params.Has("coalesce") {
+		return false, nil
+	}
+	coalesce, err := params.GetBool("coalesce")
+	if err != nil {
+		return false, err
+	}
+	if coalesce {
+		arguments.PushBack("-coalesce")
+	}
+	return coalesce, nil
+}
+
+// buildArgumentsOptimizeLayers optimizes the coalesced frames again, if the output format is gif.
+func (hdr *Handler) buildArgumentsOptimizeLayers(arguments *list.List, coalesce bool, format string) {
+	if !coalesce || format != "gif" {
+		return
+	}
+	if hdr.Backend == ImageMagick {
+		arguments.PushBack("-layers")
+		arguments.PushBack("optimize")
+		return
+	}
+	arguments.PushBack("-deconstruct")
+}
+
+// buildArgumentsTrim adds "-fuzz" before "-trim", because it is a setting used by "-trim".
+// It is reset after, so it doesn't apply to the next operations.
+func (hdr *Handler) buildArgumentsTrim(arguments *list.List, params imageserver.Params) error {
+	trim := false
+	if params.Has("trim") {
+		var err error
+		trim, err = params.GetBool("trim")
+		if err != nil {
+			return err
+		}
+	}
+	if !trim {
+		if params.Has("trim_fuzz") {
+			return &imageserver.ParamError{Param: "trim_fuzz", Message: "can't be used without trim"}
+		}
+		return nil
+	}
+	return buildArgumentsFuzz(arguments, params, "trim_fuzz", "-trim", "+repage")
+}
+
+// buildArgumentsFuzz adds the operation arguments, surrounded by the "-fuzz" setting from the fuzzParam param (if it is set) and its reset.
+func buildArgumentsFuzz(arguments *list.List, params imageserver.Params, fuzzParam string, operation ...string) error {
+	fuzz := ""
+	if params.Has(fuzzParam) {
+		f, err := params.GetFloat(fuzzParam)
+		if err != nil {
+			return err
+		}
+		if f < 0 || f > 100 {
+			return &imageserver.ParamError{Param: fuzzParam, Message: "must be between 0 and 100"}
+		}
+		fuzz = formatFloat(f) + "%"
+	}
+	if fuzz != "" {
+		arguments.PushBack("-fuzz")
+		arguments.PushBack(fuzz)
+	}
+	for _, a := range operation {
+		arguments.PushBack(a)
+	}
+	if fuzz != "" {
+		arguments.PushBack("-fuzz")
+		arguments.PushBack("0%")
+	}
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsTransparent(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("transparent") {
+		if params.Has("transparent_fuzz") {
+			return &imageserver.ParamError{Param: "transparent_fuzz", Message: "can't be used without transparent"}
+		}
+		return nil
+	}
+	transparent, err := params.GetString("transparent")
+	if err != nil {
+		return err
+	}
+	err = validateColor("transparent", transparent)
+	if err != nil {
+		return err
+	}
+	return buildArgumentsFuzz(arguments, params, "transparent_fuzz", "-transparent", "#"+transparent)
+}
+
+// buildArgumentsTranspose must be called before buildArgumentsResize, because it swaps width and height.
+//
+// GraphicsMagick doesn't support "-transpose" and "-transverse", so they are implemented with "-flip"/"-flop" and "-rotate 90".
+// The ImageMagick Backend uses them directly.
+// If both are enabled, the result is a 180° rotation.
+func (hdr *Handler) buildArgumentsTranspose(arguments *list.List, params imageserver.Params) error {
+	return hdr.buildArgumentsDiagonalMirror(arguments, params, "transpose", "-flip")
+}
+
+func (hdr *Handler) buildArgumentsTransverse(arguments *list.List, params imageserver.Params) error {
+	return hdr.buildArgumentsDiagonalMirror(arguments, params, "transverse", "-flop")
+}
+
+// buildArgumentsDiagonalMirror adds "-<name>" for the ImageMagick Backend.
+// GraphicsMagick doesn't support it, so the mirror argument followed by "-rotate 90" is used instead.
+func (hdr *Handler) buildArgumentsDiagonalMirror(arguments *list.List, params imageserver.Params, name string, mirror string) error {
+	if !params.Has(name) {
+		return nil
+	}
+	enabled, err := params.GetBool(name)
+	if err != nil || !enabled {
+		return err
+	}
+	if hdr.Backend == ImageMagick {
+		arguments.PushBack("-" + name)
+		return nil
+	}
+	arguments.PushBack(mirror)
+	arguments.PushBack("-rotate")
+	arguments.PushBack("90")
+	return nil
+}
+
+// cropComponent is a component of the "crop" param, in pixels or percentage.
+type cropComponent struct {
+	value   float64
+	percent bool
+}
+
+// resolve returns the value in pixels, size is the Image dimension used by percentages.
+func (c cropComponent) resolve(size int, dpr float64) int {
+	if c.percent {
+		return int(math.Round(float64(size) * c.value / 100))
+	}
+	return int(math.Round(c.value * dpr))
+}
+
+var cropNames = []string{"width", "height", "x", "y"}
+
+// buildArgumentsCrop returns the crop size, or 0 if there is no crop.
+func (hdr *Handler) buildArgumentsCrop(arguments *list.List, params imageserver.Params, im *imageserver.Image) (width int, height int, err error) {
+	if !params.Has("crop") {
+		return 0, 0, nil
+	}
+	if params.Has("crop_ratio") {
+		return 0, 0, &imageserver.ParamError{Param: "crop", Message: "can't be used with crop_ratio"}
+	}
+	components, err := parseCrop(params)
+	if err != nil {
+		return 0, 0, err
+	}
+	dpr, err := hdr.getDPR(params)
+	if err != nil {
+		return 0, 0, err
+	}
+	imageWidth, imageHeight := 0, 0
+	for _, c := range components {
+		if c.percent {
+			imageWidth, imageHeight, err = getSourceDimensions(params, im)
+			if err != nil {
+				return 0, 0, err
+			}
+			break
+		}
+	}
+	width = components[0].resolve(imageWidth, dpr)
+	height = components[1].resolve(imageHeight, dpr)
+	x := components[2].resolve(imageWidth, dpr)
+	y := components[3].resolve(imageHeight, dpr)
+	if width <= 0 || height <= 0 {
+		return 0, 0, &imageserver.ParamError{Param: "crop", Message: "width and height must be greater than 0"}
+	}
+	err = hdr.checkMinSize("crop", "crop", width, height)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = hdr.checkMaxSize("crop", width, height)
+	if err != nil {
+		return 0, 0, err
+	}
+	arguments.PushBack("-crop")
+	arguments.PushBack(fmt.Sprintf("%dx%d+%d+%d", width, height, x, y))
+	arguments.PushBack("+repage")
+	return width, height, nil
+}
+
+// parseCrop parses the "crop" param, "<width>,<height>,<x>,<y>".
+func parseCrop(params imageserver.Params) ([]cropComponent, error) {
+	s, err := params.GetString("crop")
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != len(cropNames) {
+		return nil, &imageserver.ParamError{Param: "crop", Message: "must be \"<width>,<height>,<x>,<y>\""}
+	}
+	components := make([]cropComponent, len(parts))
+	for i, part := range parts {
+		c, err := parseCropComponent(part)
+		if err != nil {
+			return nil, &imageserver.ParamError{Param: "crop", Message: fmt.Sprintf("%s: %s", cropNames[i], err)}
+		}
+		components[i] = c
+	}
+	return components, nil
+}
+
+// parseCropComponent parses a "crop" param component, "<pixels>" or "<percentage>%".
+func parseCropComponent(s string) (cropComponent, error) {
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return cropComponent{}, err
+		}
+		if v < 0 || v > 100 {
+			return cropComponent{}, fmt.Errorf("percentage must be between 0 and 100")
+		}
+		return cropComponent{value: v, percent: true}, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return cropComponent{}, err
+	}
+	if v < 0 {
+		return cropComponent{}, fmt.Errorf("must be greater than or equal to 0")
+	}
+	return cropComponent{value: float64(v)}, nil
+}
+
+// nolint: gocyclo
+func (hdr *Handler) buildArgumentsCropRatio(arguments *list.List, params imageserver.Params, im *imageserver.Image) (width int, height int, err error) {
+	if !params.Has("crop_ratio") {
+		for _, name := range []string{"crop_focus_x", "crop_focus_y"} {
+			if params.Has(name) {
+				return 0, 0, &imageserver.ParamError{Param: name, Message: "can't be used without crop_ratio"}
+			}
+		}
+		return 0, 0, nil
+	}
+	ratioWidth, ratioHeight, err := parseCropRatio(params)
+	if err != nil {
+		return 0, 0, err
+	}
+	focusX, focusY, focus, err := getCropFocus(params)
+	if err != nil {
+		return 0, 0, err
+	}
+	gravity := ""
+	if !focus {
+		gravity, err = getGravity(params)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	imageWidth, imageHeight, err := getSourceDimensions(params, im)
+	if err != nil {
+		return 0, 0, err
+	}
+	width, height = getCropRatioSize(imageWidth, imageHeight, ratioWidth, ratioHeight)
+	x, y := 0, 0
+	if focus {
+		x = getCropFocusOffset(imageWidth, width, focusX)
+		y = getCropFocusOffset(imageHeight, height, focusY)
+	} else {
+		arguments.PushBack("-gravity")
+		arguments.PushBack(gravity)
+	}
+	arguments.PushBack("-crop")
+	arguments.PushBack(fmt.Sprintf("%dx%d+%d+%d", width, height, x, y))
+	arguments.PushBack("+repage")
+	return width, height, nil
+}
+
+// getCropFocus returns the "crop_focus_x" and "crop_focus_y" params (50 by default).
+//
+// ok is false if there is no focus param.
+func getCropFocus(params imageserver.Params) (x float64, y float64, ok bool, err error) {
+	x, y = 50, 50
+	for _, f := range []struct {
+		name  string
+		value *float64
+	}{
+		{name: "crop_focus_x", value: &x},
+		{name: "crop_focus_y", value: &y},
+	} {
+		if !params.Has(f.name) {
+			continue
+		}
+		v, err := params.GetFloat(f.name)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if v < 0 || v > 100 {
+			return 0, 0, false, &imageserver.ParamError{Param: f.name, Message: "must be between 0 and 100"}
+		}
+		*f.value = v
+		ok = true
+	}
+	return x, y, ok, nil
+}
+
+// getCropFocusOffset returns the offset of a crop window of size cropSize centered on the focus percentage, clamped to the Image bounds.
+func getCropFocusOffset(imageSize, cropSize int, focus float64) int {
+	offset := int(math.Round(float64(imageSize)*focus/100 - float64(cropSize)/2))
+	if offset > imageSize-cropSize {
+		offset = imageSize - cropSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return offset
+}
+
+// parseCropRatio parses the "crop_ratio" param, "<width>:<height>".
+func parseCropRatio(params imageserver.Params) (width int, height int, err error) {
+	s, err := params.GetString("crop_ratio")
+	if err != nil {
+		return 0, 0, err
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, &imageserver.ParamError{Param: "crop_ratio", Message: "must be \"<width>:<height>\""}
+	}
+	width, err = strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, &imageserver.ParamError{Param: "crop_ratio", Message: "width must be a positive integer"}
+	}
+	height, err = strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return 0, 0, &imageserver.ParamError{Param: "crop_ratio", Message: "height must be a positive integer"}
+	}
+	return width, height, nil
+}
+
+// getCropRatioSize returns the largest size with the ratioWidth:ratioHeight aspect ratio that fits inside width x height.
+func getCropRatioSize(width, height, ratioWidth, ratioHeight int) (int, int) {
+	if width*ratioHeight > height*ratioWidth {
+		width = int(math.Round(float64(height*ratioWidth) / float64(ratioHeight)))
+	} else {
+		height = int(math.Round(float64(width*ratioHeight) / float64(ratioWidth)))
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
+
+// getBaseDimensions returns the crop size if it is set, or the source Image dimensions.
+func getBaseDimensions(params imageserver.Params, im *imageserver.Image, cropWidth int, cropHeight int) (width int, height int, err error) {
+	if cropWidth != 0 && cropHeight != 0 {
+		return cropWidth, cropHeight, nil
+	}
+	return getSourceDimensions(params, im)
+}
+
+// getSourceDimensions returns the dimensions of the source Image (read from its header), swapped if it is transposed.
+func getSourceDimensions(params imageserver.Params, im *imageserver.Image) (width int, height int, err error) {
+	width, height, err = decodeDimensions(im.Data)
+	if err != nil {
+		return 0, 0, err
+	}
+	transposed, err := isTransposed(params)
+	if err != nil {
+		return 0, 0, err
+	}
+	if transposed {
+		width, height = height, width
+	}
+	return width, height, nil
+}
+
+// isTransposed returns true if the "transpose" and "transverse" params swap the Image dimensions.
+func isTransposed(params imageserver.Params) (bool, error) {
+	transposed := false
+	for _, name := range []string{"transpose", "transverse"} {
+		if !params.Has(name) {
+			continue
+		}
+		enabled, err := params.GetBool(name)
+		if err != nil {
+			return false, err
+		}
+		if enabled {
+			transposed = !transposed
+		}
+	}
+	return transposed, nil
+}
+
+func (hdr *Handler) buildArgumentsResize(arguments *list.List, params imageserver.Params, im *imageserver.Image, cropWidth int, cropHeight int) (width int, height int, geometry string, err error) {
+	dpr, err := hdr.getDPR(params)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	width, height, geometry, err = getResizeGeometry(params, dpr)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	operator, err := getResizeOperator(params)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	filter, err := hdr.getFilter(params)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if geometry == "" {
+		if operator != "-resize" {
+			return 0, 0, "", &imageserver.ParamError{Param: "resize_mode", Message: "can't be used without width, height, resize_percent or resize_area"}
+		}
+		if params.Has("filter") {
+			return 0, 0, "", &imageserver.ParamError{Param: "filter", Message: "can't be used without width, height, resize_percent or resize_area"}
+		}
+		return 0, 0, "", nil
+	}
+	err = hdr.checkMinSize("width", "height", width, height)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	err = hdr.checkResizeMaxSize(params, im, geometry, cropWidth, cropHeight)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if filter != "" {
+		arguments.PushBack("-filter")
+		arguments.PushBack(filter)
+	}
+	arguments.PushBack(operator)
+	arguments.PushBack(geometry)
+	return width, height, geometry, nil
+}
+
+// checkResizeMaxSize checks the resize output size against MaxWidth, MaxHeight and MaxPixels.
+//
+// It returns an ImageError if the Image header can't be decoded, because the output size can't be computed.
+func (hdr *Handler) checkResizeMaxSize(params imageserver.Params, im *imageserver.Image, geometry string, cropWidth int, cropHeight int) error {
+	if !hdr.hasMaxSize() {
+		return nil
+	}
+	baseWidth, baseHeight, err := getBaseDimensions(params, im, cropWidth, cropHeight)
+	if err != nil {
+		return err
+	}
+	width, height := getResizeSize(geometry, baseWidth, baseHeight)
+	return hdr.checkMaxSize(getResizeParam(params), width, height)
+}
+
+// getResizeParam returns the name of the param that defines the resize size.
+func getResizeParam(params imageserver.Params) string {
+	for _, name := range []string{"resize_area", "resize_percent", "width"} {
+		if params.Has(name) {
+			return name
+		}
+	}
+	return "height"
+}
+
+// getResizeSize returns the size of an Image of size baseWidth x baseHeight, resized with the geometry (as built by getResizeGeometry).
+//
+// nolint: gocyclo
+func getResizeSize(geometry string, baseWidth int, baseHeight int) (width int, height int) {
+	if strings.HasSuffix(geometry, "%") {
+		percent, _ := strconv.ParseFloat(strings.TrimSuffix(geometry, "%"), 64)
+		return scaleSize(baseWidth, baseHeight, percent/100, percent/100)
+	}
+	if strings.HasSuffix(geometry, "@") {
+		area, _ := strconv.Atoi(strings.TrimSuffix(geometry, "@"))
+		scale := math.Sqrt(float64(area) / float64(baseWidth*baseHeight))
+		return scaleSize(baseWidth, baseHeight, scale, scale)
+	}
+	size := strings.TrimRight(geometry, "^!><")
+	modifiers := geometry[len(size):]
+	i := strings.IndexByte(size, 'x')
+	width, _ = strconv.Atoi(size[:i])
+	height, _ = strconv.Atoi(size[i+1:])
+	scaleX := float64(width) / float64(baseWidth)
+	scaleY := float64(height) / float64(baseHeight)
+	switch {
+	case width == 0:
+		scaleX = scaleY
+	case height == 0:
+		scaleY = scaleX
+	case strings.Contains(modifiers, "!"):
+	case strings.Contains(modifiers, "^"):
+		scaleX = math.Max(scaleX, scaleY)
+		scaleY = scaleX
+	default:
+		scaleX = math.Min(scaleX, scaleY)
+		scaleY = scaleX
+	}
+	if (strings.Contains(modifiers, ">") && scaleX > 1 && scaleY > 1) || (strings.Contains(modifiers, "<") && scaleX < 1 && scaleY < 1) {
+		scaleX, scaleY = 1, 1
+	}
+	return scaleSize(baseWidth, baseHeight, scaleX, scaleY)
+}
+
+// scaleSize returns the scaled size, rounded to the nearest integer.
+func scaleSize(width int, height int, scaleX float64, scaleY float64) (int, int) {
+	return int(math.Round(float64(width) * scaleX)), int(math.Round(float64(height) * scaleY))
+}
+
+// checkMinSize returns a ParamError if the width or height (0 is ignored) is lower than MinWidth or MinHeight.
+func (hdr *Handler) checkMinSize(widthParam string, heightParam string, width int, height int) error {
+	if width != 0 && width < hdr.MinWidth {
+		return &imageserver.ParamError{Param: widthParam, Message: fmt.Sprintf("width must be greater than or equal to %d", hdr.MinWidth)}
+	}
+	if height != 0 && height < hdr.MinHeight {
+		return &imageserver.ParamError{Param: heightParam, Message: fmt.Sprintf("height must be greater than or equal to %d", hdr.MinHeight)}
+	}
+	return nil
+}
+
+func (hdr *Handler) hasMaxSize() bool {
+	return hdr.MaxWidth > 0 || hdr.MaxHeight > 0 || hdr.MaxPixels > 0
+}
+
+// checkMaxSize returns a ParamError for param if the size exceeds MaxWidth, MaxHeight or MaxPixels.
+func (hdr *Handler) checkMaxSize(param string, width int, height int) error {
+	if hdr.MaxWidth > 0 && width > hdr.MaxWidth {
+		return &imageserver.ParamError{Param: param, Message: fmt.Sprintf("output width %d is greater than %d", width, hdr.MaxWidth)}
+	}
+	if hdr.MaxHeight > 0 && height > hdr.MaxHeight {
+		return &imageserver.ParamError{Param: param, Message: fmt.Sprintf("output height %d is greater than %d", height, hdr.MaxHeight)}
+	}
+	if hdr.MaxPixels > 0 && width*height > hdr.MaxPixels {
+		return &imageserver.ParamError{Param: param, Message: fmt.Sprintf("output pixels count %d is greater than %d", width*height, hdr.MaxPixels)}
+	}
+	return nil
+}
+
+// getResizeGeometry returns the resize geometry from the "width"/"height", "resize_percent" or "resize_area" params.
+//
+// The geometry is empty if there is no resize.
+// The width and height are multiplied by dpr.
+//
+// nolint: gocyclo
+func getResizeGeometry(params imageserver.Params, dpr float64) (width int, height int, geometry string, err error) {
+	width, err = getDimension("width", params)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	height, err = getDimension("height", params)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if params.Has("dpr") && width == 0 && height == 0 {
+		return 0, 0, "", &imageserver.ParamError{Param: "dpr", Message: "can't be used without width or height"}
+	}
+	width = applyDPR(width, dpr)
+	height = applyDPR(height, dpr)
+	fit, fitOK, err := getFitMode(params)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if fitOK {
+		if width == 0 && height == 0 {
+			return 0, 0, "", &imageserver.ParamError{Param: "fit", Message: "can't be used without width or height"}
+		}
+		if (fit.extent || fit.modifier == "!") && (width == 0 || height == 0) {
+			return 0, 0, "", &imageserver.ParamError{Param: "fit", Message: "requires width and height"}
+		}
+	}
+	if params.Has("resize_area") {
+		if width != 0 || height != 0 || params.Has("resize_percent") {
+			return 0, 0, "", &imageserver.ParamError{Param: "resize_area", Message: "can't be used with width, height or resize_percent"}
+		}
+		geometry, err = getResizeAreaGeometry(params)
+		return 0, 0, geometry, err
+	}
+	if params.Has("resize_percent") {
+		if width != 0 || height != 0 {
+			return 0, 0, "", &imageserver.ParamError{Param: "resize_percent", Message: "can't be used with width or height"}
+		}
+		geometry, err = getResizePercentGeometry(params)
+		return 0, 0, geometry, err
+	}
+	if width == 0 && height == 0 {
+		return 0, 0, "", nil
+	}
+	widthString := ""
+	if width != 0 {
+		widthString = strconv.Itoa(width)
+	}
+	heightString := ""
+	if height != 0 {
+		heightString = strconv.Itoa(height)
+	}
+	geometry = fmt.Sprintf("%sx%s", widthString, heightString)
+	if fitOK {
+		geometry = geometry + fit.modifier
+	}
+	if params.Has("fill") {
+		fill, err := params.GetBool("fill")
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if fill {
+			geometry = geometry + "^"
+		}
+	}
+	if params.Has("ignore_ratio") {
+		ignoreRatio, err := params.GetBool("ignore_ratio")
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if ignoreRatio {
+			geometry = geometry + "!"
+		}
+	}
+	if params.Has("only_shrink_larger") {
+		onlyShrinkLarger, err := params.GetBool("only_shrink_larger")
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if onlyShrinkLarger {
+			geometry = geometry + ">"
+		}
+	}
+	if params.Has("only_enlarge_smaller") {
+		onlyEnlargeSmaller, err := params.GetBool("only_enlarge_smaller")
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if onlyEnlargeSmaller {
+			geometry = geometry + "<"
+		}
+	}
+	return width, height, geometry, nil
+}
+
+// getFitMode returns the fitMode from the "fit" param.
+//
+// ok is false if there is no "fit" param.
+func getFitMode(params imageserver.Params) (mode fitMode, ok bool, err error) {
+	if !params.Has("fit") {
+		return fitMode{}, false, nil
+	}
+	for _, name := range []string{"fill", "ignore_ratio", "extent", "pad"} {
+		if params.Has(name) {
+			return fitMode{}, false, &imageserver.ParamError{Param: name, Message: "can't be used with fit"}
+		}
+	}
+	fit, err := params.GetString("fit")
+	if err != nil {
+		return fitMode{}, false, err
+	}
+	mode, ok = fitModes[fit]
+	if !ok {
+		return fitMode{}, false, &imageserver.ParamError{Param: "fit", Message: "must be cover, contain, fill, inside or outside"}
+	}
+	return mode, true, nil
+}
+
+// getResizeOperator returns the resize operator from the "resize_mode" param ("-resize" by default).
+func getResizeOperator(params imageserver.Params) (string, error) {
+	if !params.Has("resize_mode") {
+		return "-resize", nil
+	}
+	mode, err := params.GetString("resize_mode")
+	if err != nil {
+		return "", err
+	}
+	if !resizeModes[mode] {
+		return "", &imageserver.ParamError{Param: "resize_mode", Message: "must be resize, thumbnail, sample or scale"}
+	}
+	return "-" + mode, nil
+}
+
+// getFilter returns the GraphicsMagick name of the "filter" param, or DefaultFilter.
+func (hdr *Handler) getFilter(params imageserver.Params) (string, error) {
+	filter := hdr.DefaultFilter
+	if params.Has("filter") {
+		var err error
+		filter, err = params.GetString("filter")
+		if err != nil {
+			return "", err
+		}
+	} else if filter == "" {
+		return "", nil
+	}
+	f, ok := filters[strings.ToLower(filter)]
+	if !ok {
+		return "", &imageserver.ParamError{Param: "filter", Message: fmt.Sprintf("must be one of %s", strings.Join(filterNames, ", "))}
+	}
+	return f, nil
+}
+
+func getResizePercentGeometry(params imageserver.Params) (string, error) {
+	percent, err := params.GetFloat("resize_percent")
+	if err != nil {
+		return "", err
+	}
+	if percent <= 0 || percent > 1000 {
+		return "", &imageserver.ParamError{Param: "resize_percent", Message: "must be greater than 0 and less than or equal to 1000"}
+	}
+	return formatFloat(percent) + "%", nil
+}
+
+func getResizeAreaGeometry(params imageserver.Params) (string, error) {
+	area, err := params.GetInt("resize_area")
+	if err != nil {
+		return "", err
+	}
+	if area <= 0 {
+		return "", &imageserver.ParamError{Param: "resize_area", Message: "must be greater than 0"}
+	}
+	return strconv.Itoa(area) + "@", nil
+}
+
+// getDPR returns the "dpr" param (1 by default), capped to MaxDPR.
+func (hdr *Handler) getDPR(params imageserver.Params) (float64, error) {
+	if !params.Has("dpr") {
+		return 1, nil
+	}
+	dpr, err := params.GetFloat("dpr")
+	if err != nil {
+		return 0, err
+	}
+	if dpr < minDPR || dpr > maxDPR {
+		return 0, &imageserver.ParamError{Param: "dpr", Message: fmt.Sprintf("must be between %s and %s", formatFloat(minDPR), formatFloat(maxDPR))}
+	}
+	if hdr.MaxDPR > 0 && dpr > hdr.MaxDPR {
+		dpr = hdr.MaxDPR
+	}
+	return dpr, nil
+}
+
+// applyDPR multiplies a dimension by dpr, and rounds it to the nearest integer.
+func applyDPR(dimension int, dpr float64) int {
+	return int(math.Round(float64(dimension) * dpr))
+}
+
+func getDimension(name string, params imageserver.Params) (int, error) {
+	if !params.Has(name) {
+		return 0, nil
+	}
+	dimension, err := params.GetInt(name)
+	if err != nil {
+		return 0, err
+	}
+	if dimension < 0 {
+		return 0, &imageserver.ParamError{Param: name, Message: "must be greater than or equal to 0"}
+	}
+	return dimension, nil
+}
+
+// buildArgumentsShave must be called after buildArgumentsResize and buildArgumentsRotate.
+//
+// The shave size doesn't depend on the output image size.
+func (hdr *Handler) buildArgumentsShave(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("shave") {
+		return nil
+	}
+	v, err := params.Get("shave")
+	if err != nil {
+		return err
+	}
+	var shave string
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return &imageserver.ParamError{Param: "shave", Message: "must be greater than or equal to 0"}
+		}
+		shave = fmt.Sprintf("%dx%d", v, v)
+	case string:
+		shave, err = parseEdgeSize("shave", v)
+		if err != nil {
+			return err
+		}
+	default:
+		return &imageserver.ParamError{Param: "shave", Message: fmt.Sprintf("contains a value of type %T instead of int or string", v)}
+	}
+	if shave == "0x0" {
+		return nil
+	}
+	arguments.PushBack("-shave")
+	arguments.PushBack(shave)
+	return nil
+}
+
+// buildArgumentsChop must be called after buildArgumentsResize and buildArgumentsShave.
+//
+// The geometry is in pixels of the resized image.
+func (hdr *Handler) buildArgumentsChop(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("chop") {
+		return nil
+	}
+	chop, err := params.GetString("chop")
+	if err != nil {
+		return err
+	}
+	chop, err = parseChop(chop)
+	if err != nil || chop == "" {
+		return err
+	}
+	arguments.PushBack("-chop")
+	arguments.PushBack(chop)
+	return nil
+}
+
+// parseChop parses a "<width>x<height>[+<x>+<y>]" value, and returns it normalized.
+//
+// It returns an empty string if the width and height are 0.
+func parseChop(s string) (string, error) {
+	parts := strings.Split(s, "+")
+	if len(parts) != 1 && len(parts) != 3 {
+		return "", &imageserver.ParamError{Param: "chop", Message: "expected format '<width>x<height>[+<x>+<y>]'"}
+	}
+	size := strings.Split(parts[0], "x")
+	if len(size) != 2 {
+		return "", &imageserver.ParamError{Param: "chop", Message: "expected format '<width>x<height>[+<x>+<y>]'"}
+	}
+	values := []int{0, 0, 0, 0}
+	for i, part := range append(size, parts[1:]...) {
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return "", &imageserver.ParamError{Param: "chop", Message: fmt.Sprintf("parse %s: %s", cropNames[i], err)}
+		}
+		if v < 0 {
+			return "", &imageserver.ParamError{Param: "chop", Message: fmt.Sprintf("%s must be greater than or equal to 0", cropNames[i])}
+		}
+		values[i] = v
+	}
+	if values[0] == 0 && values[1] == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("%dx%d+%d+%d", values[0], values[1], values[2], values[3]), nil
+}
+
+// buildArgumentsBlur must be called after buildArgumentsResize.
+//
+// The "-blur" argument is applied after the resize, so its cost depends on the output image size.
+func (hdr *Handler) buildArgumentsBlur(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("blur") {
+		return nil
+	}
+	blur, err := params.GetString("blur")
+	if err != nil {
+		return err
+	}
+	radius, sigma, err := parseRadiusSigma("blur", blur)
+	if err != nil {
+		return err
+	}
+	if hdr.MaxBlurSigma != 0 && sigma > hdr.MaxBlurSigma {
+		return &imageserver.ParamError{Param: "blur", Message: fmt.Sprintf("sigma must be less than or equal to %s", formatFloat(hdr.MaxBlurSigma))}
+	}
+	arguments.PushBack("-blur")
+	arguments.PushBack(formatRadiusSigma(radius, sigma))
+	return nil
+}
+
+// buildArgumentsDensity must be called last, because "-density" is pushed to the front.
+//
+// It must be set before the Image is read.
+func (hdr *Handler) buildArgumentsDensity(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("density") {
+		return nil
+	}
+	s, err := params.GetString("density")
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(s, "x")
+	if len(parts) > len(densityNames) {
+		return &imageserver.ParamError{Param: "density", Message: "must be \"<dpi>\" or \"<x_dpi>x<y_dpi>\""}
+	}
+	formatted := make([]string, 0, len(parts))
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return &imageserver.ParamError{Param: "density", Message: fmt.Sprintf("parse %s: %s", densityNames[i], err)}
+		}
+		if f <= 0 {
+			return &imageserver.ParamError{Param: "density", Message: fmt.Sprintf("%s must be greater than 0", densityNames[i])}
+		}
+		formatted = append(formatted, formatFloat(f))
+	}
+	arguments.PushFront(strings.Join(formatted, "x"))
+	arguments.PushFront("-density")
+	return nil
+}
+
+// buildArgumentsAutoOrient must be called before buildArgumentsResize.
+//
+// The "-auto-orient" argument rotates the image according to the EXIF orientation.
+// It doesn't change images without EXIF orientation.
+//
+// It is pushed to the front of the arguments, so the following geometry arguments (resize, extent, ...) are applied to the visually correct image.
+func (hdr *Handler) buildArgumentsAutoOrient(arguments *list.List, params imageserver.Params) error {
+	autoOrient := hdr.AutoOrientDefault
+	if params.Has("auto_orient") {
+		var err error
+		autoOrient, err = params.GetBool("auto_orient")
+		if err != nil {
+			return err
+		}
+	}
+	if autoOrient {
+		arguments.PushFront("-auto-orient")
+	}
+	return nil
+}
+
+// buildArgumentsGaussianBlur must be called before buildArgumentsResize.
+//
+// The "-gaussian" argument ("-gaussian-blur" for the ImageMagick Backend) is applied before the resize, on the source image.
+// It is expensive, so its cost depends on the source image size.
+func (hdr *Handler) buildArgumentsGaussianBlur(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("gaussian_blur") {
+		return nil
+	}
+	gaussianBlur, err := params.GetString("gaussian_blur")
+	if err != nil {
+		return err
+	}
+	if !strings.ContainsAny(gaussianBlur, radiusSigmaSeparators) {
+		return &imageserver.ParamError{Param: "gaussian_blur", Message: "expected format '<radius>x<sigma>' or '<radius>,<sigma>'"}
+	}
+	radius, sigma, err := parseRadiusSigma("gaussian_blur", gaussianBlur)
+	if err != nil {
+		return err
+	}
+	if sigma <= 0 {
+		return &imageserver.ParamError{Param: "gaussian_blur", Message: "sigma must be greater than 0"}
+	}
+	if hdr.Backend == ImageMagick {
+		arguments.PushBack("-gaussian-blur")
+	} else {
+		arguments.PushBack("-gaussian")
+	}
+	arguments.PushBack(formatRadiusSigma(radius, sigma))
+	return nil
+}
+
+// buildArgumentsDespeckle must be called before buildArgumentsResize.
+//
+// The noise is reduced before it is amplified by the resize.
+func (hdr *Handler) buildArgumentsDespeckle(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("despeckle") {
+		return nil
+	}
+	v, err := params.Get("despeckle")
+	if err != nil {
+		return err
+	}
+	var count int
+	switch v := v.(type) {
+	case bool:
+		if v {
+			count = 1
+		}
+	case int:
+		count = v
+	default:
+		return &imageserver.ParamError{Param: "despeckle", Message: fmt.Sprintf("contains a value of type %T instead of int or bool", v)}
+	}
+	if count < 0 || count > maxDespeckle {
+		return &imageserver.ParamError{Param: "despeckle", Message: fmt.Sprintf("must be between 0 and %d", maxDespeckle)}
+	}
+	if count == 0 {
+		return nil
+	}
+	err = hdr.checkExpensiveFilter("despeckle")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		arguments.PushBack("-despeckle")
+	}
+	return nil
+}
+
+// buildArgumentsMedian must be called before buildArgumentsResize.
+func (hdr *Handler) buildArgumentsMedian(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("median") {
+		return nil
+	}
+	median, err := params.GetInt("median")
+	if err != nil {
+		return err
+	}
+	if median < 1 || median > 9 || median%2 == 0 {
+		return &imageserver.ParamError{Param: "median", Message: "must be an odd number between 1 and 9"}
+	}
+	err = hdr.checkExpensiveFilter("median")
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-median")
+	arguments.PushBack(strconv.Itoa(median))
+	return nil
+}
+
+func (hdr *Handler) checkExpensiveFilter(name string) error {
+	if !hdr.AllowExpensiveFilters {
+		return &imageserver.ParamError{Param: name, Message: "expensive filters are not allowed"}
+	}
+	return nil
+}
+
+// buildArgumentsSharpen must be called after buildArgumentsResize.
+//
+// The "-sharpen" argument is applied to the resized image.
+func (hdr *Handler) buildArgumentsSharpen(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("sharpen") {
+		return nil
+	}
+	v, err := params.Get("sharpen")
+	if err != nil {
+		return err
+	}
+	var sharpen string
+	switch v := v.(type) {
+	case bool:
+		if !v {
+			return nil
+		}
+		sharpen = defaultSharpen
+	case string:
+		radius, sigma, err := parseRadiusSigma("sharpen", v)
+		if err != nil {
+			return err
+		}
+		if radius == 0 && sigma == 0 {
+			return &imageserver.ParamError{Param: "sharpen", Message: "radius and sigma must not be both equal to 0"}
+		}
+		sharpen = formatRadiusSigma(radius, sigma)
+	default:
+		return &imageserver.ParamError{Param: "sharpen", Message: fmt.Sprintf("contains a value of type %T instead of bool or string", v)}
+	}
+	arguments.PushBack("-sharpen")
+	arguments.PushBack(sharpen)
+	return nil
+}
+
+// buildArgumentsUnsharp must be called after buildArgumentsResize.
+//
+// The "-unsharp" argument is applied to the resized image.
+func (hdr *Handler) buildArgumentsUnsharp(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("unsharp") {
+		return nil
+	}
+	unsharp, err := params.GetString("unsharp")
+	if err != nil {
+		return err
+	}
+	unsharp, err = parseUnsharp("unsharp", unsharp)
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-unsharp")
+	arguments.PushBack(unsharp)
+	return nil
+}
+
+// parseUnsharp parses a "<radius>x<sigma>[+<amount>[+<threshold>]]" value, and returns it normalized.
+func parseUnsharp(name string, s string) (string, error) {
+	parts := strings.Split(s, "+")
+	if len(parts) > 3 || !strings.ContainsAny(parts[0], radiusSigmaSeparators) {
+		return "", &imageserver.ParamError{Param: name, Message: "expected format '<radius>x<sigma>[+<amount>[+<threshold>]]'"}
+	}
+	radius, sigma, err := parseRadiusSigma(name, parts[0])
+	if err != nil {
+		return "", err
+	}
+	res := formatRadiusSigma(radius, sigma)
+	if len(parts) > 1 {
+		amount, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return "", &imageserver.ParamError{Param: name, Message: fmt.Sprintf("parse amount: %s", err)}
+		}
+		if amount < 0 || amount > 5 {
+			return "", &imageserver.ParamError{Param: name, Message: "amount must be between 0 and 5"}
+		}
+		res += "+" + formatFloat(amount)
+	}
+	if len(parts) > 2 {
+		threshold, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return "", &imageserver.ParamError{Param: name, Message: fmt.Sprintf("parse threshold: %s", err)}
+		}
+		if threshold < 0 {
+			return "", &imageserver.ParamError{Param: name, Message: "threshold must be greater than or equal to 0"}
+		}
+		res += "+" + formatFloat(threshold)
+	}
+	return res, nil
+}
+
+// buildArgumentsType must be called before buildArgumentsColorspace and buildArgumentsColors.
+func (hdr *Handler) buildArgumentsType(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("type") {
+		return nil
+	}
+	typ, err := params.GetString("type")
+	if err != nil {
+		return err
+	}
+	for _, t := range imageTypes {
+		if strings.EqualFold(t, typ) {
+			arguments.PushBack("-type")
+			arguments.PushBack(t)
+			return nil
+		}
+	}
+	return &imageserver.ParamError{Param: "type", Message: fmt.Sprintf("must be one of %s", strings.Join(imageTypes, ", "))}
+}
+
+func (hdr *Handler) buildArgumentsColorspace(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("colorspace") {
+		return nil
+	}
+	colorspace, err := params.GetString("colorspace")
+	if err != nil {
+		return err
+	}
+	for _, cs := range colorspaces {
+		if strings.EqualFold(cs, colorspace) {
+			arguments.PushBack("-colorspace")
+			arguments.PushBack(cs)
+			return nil
+		}
+	}
+	return &imageserver.ParamError{Param: "colorspace", Message: fmt.Sprintf("must be one of %s", strings.Join(colorspaces, ", "))}
+}
+
+// buildArgumentsNormalize must be called before buildArgumentsEqualize.
+func (hdr *Handler) buildArgumentsNormalize(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("normalize") {
+		return nil
+	}
+	normalize, err := params.GetBool("normalize")
+	if err != nil {
+		return err
+	}
+	if normalize {
+		arguments.PushBack("-normalize")
+	}
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsEqualize(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("equalize") {
+		return nil
+	}
+	equalize, err := params.GetBool("equalize")
+	if err != nil {
+		return err
+	}
+	if !equalize {
+		return nil
+	}
+	if params.Has("normalize") {
+		normalize, err := params.GetBool("normalize")
+		if err != nil {
+			return err
+		}
+		if normalize {
+			return &imageserver.ParamError{Param: "equalize", Message: "can't be used with normalize"}
+		}
+	}
+	arguments.PushBack("-equalize")
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsLevel(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("level") {
+		return nil
+	}
+	level, err := params.GetString("level")
+	if err != nil {
+		return err
+	}
+	level, err = parseLevel(level)
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-level")
+	arguments.PushBack(level)
+	return nil
+}
+
+// parseLevel parses a "<black_point>[,<gamma>[,<white_point>]]" value, and returns it normalized.
+//
+// The black/white points are percentages ("5%") or absolute values between 0 and 255.
+// The black point must be lower than the white point.
+// The gamma is between 0.1 and 10.
+func parseLevel(s string) (string, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) > 3 {
+		return "", &imageserver.ParamError{Param: "level", Message: "expected format '<black_point>[,<gamma>[,<white_point>]]'"}
+	}
+	res := make([]string, 0, len(parts))
+	var points []float64
+	for i, part := range parts {
+		var v string
+		var err error
+		if i == 1 {
+			v, err = parseLevelGamma(part)
+		} else {
+			var p float64
+			v, p, err = parseLevelPoint(levelNames[i], part)
+			points = append(points, p)
+		}
+		if err != nil {
+			return "", err
+		}
+		res = append(res, v)
+	}
+	if len(points) == 2 && points[0] >= points[1] {
+		return "", &imageserver.ParamError{Param: "level", Message: "black point must be lower than white point"}
+	}
+	return strings.Join(res, ","), nil
+}
+
+// parseLevelPoint returns the normalized value, and the point relative to the maximum value (between 0 and 1).
+func parseLevelPoint(name string, s string) (string, float64, error) {
+	maxValue := 255.0
+	suffix := ""
+	if strings.HasSuffix(s, "%") {
+		s = strings.TrimSuffix(s, "%")
+		maxValue = 100
+		suffix = "%"
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", 0, &imageserver.ParamError{Param: "level", Message: fmt.Sprintf("parse %s: %s", name, err)}
+	}
+	if f < 0 || f > maxValue {
+		return "", 0, &imageserver.ParamError{Param: "level", Message: fmt.Sprintf("%s must be between 0 and %s%s", name, formatFloat(maxValue), suffix)}
+	}
+	return formatFloat(f) + suffix, f / maxValue, nil
+}
+
+func parseLevelGamma(s string) (string, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", &imageserver.ParamError{Param: "level", Message: fmt.Sprintf("parse gamma: %s", err)}
+	}
+	if f < 0.1 || f > 10 {
+		return "", &imageserver.ParamError{Param: "level", Message: "gamma must be between 0.1 and 10"}
+	}
+	return formatFloat(f), nil
+}
+
+// buildArgumentsContrastStretch must be called after buildArgumentsResize.
+//
+// The histogram is computed on the resized image.
+func (hdr *Handler) buildArgumentsContrastStretch(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("contrast_stretch") {
+		return nil
+	}
+	if hdr.Backend != ImageMagick {
+		return &imageserver.ParamError{Param: "contrast_stretch", Message: "not supported by GraphicsMagick"}
+	}
+	contrastStretch, err := params.GetString("contrast_stretch")
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if strings.Contains(contrastStretch, "x") {
+		sep = "x"
+	}
+	parts := strings.Split(contrastStretch, sep)
+	if len(parts) > 2 || (sep == "x" && len(parts) != 2) {
+		return &imageserver.ParamError{Param: "contrast_stretch", Message: "expected format '<low>', '<low>,<high>' or '<low>%x<high>%'"}
+	}
+	values := make([]float64, 0, 2)
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(part, "%"), 64)
+		if err != nil {
+			return &imageserver.ParamError{Param: "contrast_stretch", Message: fmt.Sprintf("parse %s: %s", contrastStretchNames[i], err)}
+		}
+		if f < 0 || f > 100 {
+			return &imageserver.ParamError{Param: "contrast_stretch", Message: fmt.Sprintf("%s must be between 0 and 100", contrastStretchNames[i])}
+		}
+		values = append(values, f)
+	}
+	if len(values) == 1 {
+		values = append(values, values[0])
+	}
+	if values[0]+values[1] >= 100 {
+		return &imageserver.ParamError{Param: "contrast_stretch", Message: "the sum of low and high must be less than 100"}
+	}
+	arguments.PushBack("-contrast-stretch")
+	arguments.PushBack(fmt.Sprintf("%s%%x%s%%", formatFloat(values[0]), formatFloat(values[1])))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsModulate(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("modulate") {
+		return hdr.buildArgumentsModulateComponents(arguments, params)
+	}
+	for _, name := range modulateNames {
+		if params.Has(name) {
+			return &imageserver.ParamError{Param: "modulate", Message: fmt.Sprintf("can't be used with %s", name)}
+		}
+	}
+	modulate, err := params.GetString("modulate")
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(modulate, ",")
+	if len(parts) > 3 {
+		return &imageserver.ParamError{Param: "modulate", Message: "expected format '<brightness>[,<saturation>[,<hue>]]'"}
+	}
+	values := []float64{100, 100, 100}
+	for i, part := range parts {
+		values[i], err = strconv.ParseFloat(part, 64)
+		if err != nil {
+			return &imageserver.ParamError{Param: "modulate", Message: fmt.Sprintf("parse %s: %s", modulateNames[i], err)}
+		}
+	}
+	for i, v := range values[:2] {
+		if v < 0 || v > 200 {
+			return &imageserver.ParamError{Param: "modulate", Message: fmt.Sprintf("%s must be between 0 and 200", modulateNames[i])}
+		}
+	}
+	arguments.PushBack("-modulate")
+	arguments.PushBack(fmt.Sprintf("%s,%s,%s", formatFloat(values[0]), formatFloat(values[1]), formatFloat(values[2])))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsModulateComponents(arguments *list.List, params imageserver.Params) error {
+	values := []int{100, 100, 100}
+	set := false
+	for i, name := range modulateNames {
+		if !params.Has(name) {
+			continue
+		}
+		v, err := params.GetInt(name)
+		if err != nil {
+			return err
+		}
+		if v < 0 || v > modulateComponentsMax[i] {
+			return &imageserver.ParamError{Param: name, Message: fmt.Sprintf("must be between 0 and %d", modulateComponentsMax[i])}
+		}
+		values[i] = v
+		set = true
+	}
+	if !set {
+		return nil
+	}
+	arguments.PushBack("-modulate")
+	arguments.PushBack(fmt.Sprintf("%d,%d,%d", values[0], values[1], values[2]))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsSigmoidalContrast(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("sigmoidal_contrast") {
+		return nil
+	}
+	if hdr.Backend != ImageMagick {
+		return &imageserver.ParamError{Param: "sigmoidal_contrast", Message: "not supported by GraphicsMagick"}
+	}
+	sigmoidalContrast, err := params.GetString("sigmoidal_contrast")
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(sigmoidalContrast, ",")
+	if len(parts) > 2 {
+		return &imageserver.ParamError{Param: "sigmoidal_contrast", Message: "expected format '<contrast>[,<mid-point>%]'"}
+	}
+	values := []float64{0, 50}
+	for i, part := range parts {
+		if i == 1 {
+			part = strings.TrimSuffix(part, "%")
+		}
+		values[i], err = strconv.ParseFloat(part, 64)
+		if err != nil {
+			return &imageserver.ParamError{Param: "sigmoidal_contrast", Message: fmt.Sprintf("parse %s: %s", sigmoidalContrastNames[i], err)}
+		}
+	}
+	if values[0] <= 0 {
+		return &imageserver.ParamError{Param: "sigmoidal_contrast", Message: "contrast must be greater than 0"}
+	}
+	if values[1] < 0 || values[1] > 100 {
+		return &imageserver.ParamError{Param: "sigmoidal_contrast", Message: "mid-point must be between 0 and 100"}
+	}
+	arguments.PushBack("-sigmoidal-contrast")
+	arguments.PushBack(fmt.Sprintf("%sx%s%%", formatFloat(values[0]), formatFloat(values[1])))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsGamma(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("gamma") {
+		return nil
+	}
+	v, err := params.Get("gamma")
+	if err != nil {
+		return err
+	}
+	var values []float64
+	switch v := v.(type) {
+	case float64:
+		values = []float64{v}
+	case string:
+		parts := strings.Split(v, ",")
+		if len(parts) != 1 && len(parts) != 3 {
+			return &imageserver.ParamError{Param: "gamma", Message: "expected format '<value>' or '<red>,<green>,<blue>'"}
+		}
+		for _, part := range parts {
+			f, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return &imageserver.ParamError{Param: "gamma", Message: fmt.Sprintf("parse float: %s", err)}
+			}
+			values = append(values, f)
+		}
+	default:
+		return &imageserver.ParamError{Param: "gamma", Message: fmt.Sprintf("contains a value of type %T instead of float or string", v)}
+	}
+	formatted := make([]string, 0, len(values))
+	for _, f := range values {
+		if f <= 0 {
+			return &imageserver.ParamError{Param: "gamma", Message: "must be greater than 0"}
+		}
+		formatted = append(formatted, formatFloat(f))
+	}
+	arguments.PushBack("-gamma")
+	arguments.PushBack(strings.Join(formatted, ","))
+	return nil
+}
+
+// buildArgumentsPosterize must be called after buildArgumentsColorspace.
+func (hdr *Handler) buildArgumentsPosterize(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("posterize") {
+		return nil
+	}
+	if hdr.Backend != ImageMagick {
+		return &imageserver.ParamError{Param: "posterize", Message: "not supported by GraphicsMagick"}
+	}
+	levels, err := params.GetInt("posterize")
+	if err != nil {
+		return err
+	}
+	if levels < 2 || levels > 255 {
+		return &imageserver.ParamError{Param: "posterize", Message: "must be between 2 and 255"}
+	}
+	arguments.PushBack("-posterize")
+	arguments.PushBack(strconv.Itoa(levels))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsThresholds(arguments *list.List, params imageserver.Params) error {
+	for _, name := range thresholdNames {
+		err := buildArgumentsThreshold(arguments, params, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildArgumentsThreshold(arguments *list.List, params imageserver.Params, name string) error {
+	if !params.Has(name) {
+		return nil
+	}
+	threshold, err := params.GetInt(name)
+	if err != nil {
+		return err
+	}
+	if threshold < 0 || threshold > 100 {
+		return &imageserver.ParamError{Param: name, Message: "must be between 0 and 100"}
+	}
+	arguments.PushBack("-" + strings.Replace(name, "_", "-", -1))
+	arguments.PushBack(fmt.Sprintf("%d%%", threshold))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsNegate(arguments *list.List, params imageserver.Params) error {
+	negate := false
+	if params.Has("negate") {
+		var err error
+		negate, err = params.GetBool("negate")
+		if err != nil {
+			return err
+		}
+	}
+	negateGraysOnly := false
+	if params.Has("negate_grays_only") {
+		var err error
+		negateGraysOnly, err = params.GetBool("negate_grays_only")
+		if err != nil {
+			return err
+		}
+	}
+	if negate && negateGraysOnly {
+		return &imageserver.ParamError{Param: "negate_grays_only", Message: "can't be used with negate"}
+	}
+	if negate {
+		arguments.PushBack("-negate")
+	}
+	if negateGraysOnly {
+		arguments.PushBack("+negate")
+	}
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsSepia(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("sepia") {
+		return nil
+	}
+	if hdr.Backend != ImageMagick {
+		return &imageserver.ParamError{Param: "sepia", Message: "not supported by GraphicsMagick"}
+	}
+	threshold, ok, err := getPercentOrBool("sepia", params, defaultSepiaThreshold)
+	if err != nil || !ok {
+		return err
+	}
+	arguments.PushBack("-sepia-tone")
+	arguments.PushBack(fmt.Sprintf("%d%%", threshold))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsSolarize(arguments *list.List, params imageserver.Params) error {
+	threshold, ok, err := getPercentOrBool("solarize", params, defaultSolarizeThreshold)
+	if err != nil || !ok {
+		return err
+	}
+	arguments.PushBack("-solarize")
+	arguments.PushBack(fmt.Sprintf("%d%%", threshold))
+	return nil
+}
+
+// buildArgumentsCharcoal must be called after buildArgumentsResize.
+//
+// The cost depends on the output image size.
+func (hdr *Handler) buildArgumentsCharcoal(arguments *list.List, params imageserver.Params) error {
+	return buildArgumentsArtisticRadius(arguments, params, "charcoal")
+}
+
+// buildArgumentsEmboss must be called after buildArgumentsResize.
+//
+// The cost depends on the output image size.
+func (hdr *Handler) buildArgumentsEmboss(arguments *list.List, params imageserver.Params) error {
+	return buildArgumentsArtisticRadius(arguments, params, "emboss")
+}
+
+// buildArgumentsOilPaint must be called after buildArgumentsResize.
+//
+// The cost depends on the output image size and the radius.
+func (hdr *Handler) buildArgumentsOilPaint(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("oil_paint") {
+		return nil
+	}
+	radius, err := params.GetInt("oil_paint")
+	if err != nil {
+		return err
+	}
+	if radius < 1 || radius > maxOilPaintRadius {
+		return &imageserver.ParamError{Param: "oil_paint", Message: fmt.Sprintf("must be between 1 and %d", maxOilPaintRadius)}
+	}
+	err = hdr.checkExpensiveFilter("oil_paint")
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-paint")
+	arguments.PushBack(strconv.Itoa(radius))
+	return nil
+}
+
+func buildArgumentsArtisticRadius(arguments *list.List, params imageserver.Params, name string) error {
+	if !params.Has(name) {
+		return nil
+	}
+	radius, err := params.GetFloat(name)
+	if err != nil {
+		return err
+	}
+	if radius < 0 || radius > maxArtisticRadius {
+		return &imageserver.ParamError{Param: name, Message: fmt.Sprintf("must be between 0 and %d", maxArtisticRadius)}
+	}
+	arguments.PushBack("-" + name)
+	arguments.PushBack(formatFloat(radius))
+	return nil
+}
+
+// getPercentOrBool returns the percentage value of a param that contains an int or a bool.
+//
+// If the value is true, it returns the default percentage.
+// It returns false if the param is not set or if the value is false.
+func getPercentOrBool(name string, params imageserver.Params, defaultPercent int) (percent int, ok bool, err error) {
+	if !params.Has(name) {
+		return 0, false, nil
+	}
+	v, err := params.Get(name)
+	if err != nil {
+		return 0, false, err
+	}
+	switch v := v.(type) {
+	case bool:
+		if !v {
+			return 0, false, nil
+		}
+		percent = defaultPercent
+	case int:
+		percent = v
+	default:
+		return 0, false, &imageserver.ParamError{Param: name, Message: fmt.Sprintf("contains a value of type %T instead of int or bool", v)}
+	}
+	if percent < 0 || percent > 100 {
+		return 0, false, &imageserver.ParamError{Param: name, Message: "must be between 0 and 100"}
+	}
+	return percent, true, nil
+}
+
+// parseRadiusSigma parses a "<radius>x<sigma>" or "<radius>,<sigma>" value.
+//
+// If only one number is given, it is the sigma and the radius is 0.
+// Both values must be greater than or equal to 0.
+func parseRadiusSigma(name string, s string) (radius float64, sigma float64, err error) {
+	radiusString, sigmaString := "0", s
+	if i := strings.IndexAny(s, radiusSigmaSeparators); i >= 0 {
+		radiusString, sigmaString = s[:i], s[i+1:]
+	}
+	radius, err = strconv.ParseFloat(radiusString, 64)
+	if err != nil {
+		return 0, 0, &imageserver.ParamError{Param: name, Message: fmt.Sprintf("parse radius: %s", err)}
+	}
+	if radius < 0 {
+		return 0, 0, &imageserver.ParamError{Param: name, Message: "radius must be greater than or equal to 0"}
+	}
+	sigma, err = strconv.ParseFloat(sigmaString, 64)
+	if err != nil {
+		return 0, 0, &imageserver.ParamError{Param: name, Message: fmt.Sprintf("parse sigma: %s", err)}
+	}
+	if sigma < 0 {
+		return 0, 0, &imageserver.ParamError{Param: name, Message: "sigma must be greater than or equal to 0"}
+	}
+	return radius, sigma, nil
+}
+
+func formatRadiusSigma(radius float64, sigma float64) string {
+	return fmt.Sprintf("%sx%s", formatFloat(radius), formatFloat(sigma))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// buildArgumentsRotate must be called after buildArgumentsBackground, because "-rotate" uses the background color.
+//
+// geometry is the resize geometry, and cropWidth/cropHeight the crop size (0 if there is no crop).
+//
+// nolint: gocyclo
+func (hdr *Handler) buildArgumentsRotate(arguments *list.List, params imageserver.Params, im *imageserver.Image, geometry string, cropWidth int, cropHeight int) error {
+	if !params.Has("rotate") {
+		if params.Has("rotate_expand") {
+			return &imageserver.ParamError{Param: "rotate_expand", Message: "can't be used without rotate"}
+		}
+		return nil
+	}
+	rotate, err := params.GetFloat("rotate")
+	if err != nil {
+		return err
+	}
+	expand := true
+	if params.Has("rotate_expand") {
+		expand, err = params.GetBool("rotate_expand")
+		if err != nil {
+			return err
+		}
+	}
+	rotate = normalizeAngle(rotate)
+	if rotate == 0 {
+		return nil
+	}
+	width, height := 0, 0
+	if !expand {
+		width, height, err = getBaseDimensions(params, im, cropWidth, cropHeight)
+		if err != nil {
+			return err
+		}
+		if geometry != "" {
+			width, height = getResizeSize(geometry, width, height)
+		}
+	}
+	arguments.PushBack("-rotate")
+	arguments.PushBack(formatFloat(rotate))
+	if !expand {
+		arguments.PushBack("-gravity")
+		arguments.PushBack(defaultGravity)
+		arguments.PushBack("-crop")
+		arguments.PushBack(fmt.Sprintf("%dx%d+0+0", width, height))
+		arguments.PushBack("+repage")
+	}
+	return nil
+}
+
+// normalizeAngle returns the angle in degrees, between 0 (included) and 360 (excluded).
+func normalizeAngle(angle float64) float64 {
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+	return angle
+}
+
+func (hdr *Handler) buildArgumentsSwirl(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("swirl") {
+		return nil
+	}
+	degrees, err := params.GetFloat("swirl")
+	if err != nil {
+		return err
+	}
+	if degrees < -360 || degrees > 360 {
+		return &imageserver.ParamError{Param: "swirl", Message: "must be between -360 and 360"}
+	}
+	err = hdr.checkDistortion("swirl")
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-swirl")
+	arguments.PushBack(formatFloat(degrees))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsImplode(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("implode") {
+		return nil
+	}
+	factor, err := params.GetFloat("implode")
+	if err != nil {
+		return err
+	}
+	if factor < -1 || factor > 1 {
+		return &imageserver.ParamError{Param: "implode", Message: "must be between -1 and 1"}
+	}
+	err = hdr.checkDistortion("implode")
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-implode")
+	arguments.PushBack(formatFloat(factor))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsWave(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("wave") {
+		return nil
+	}
+	s, err := params.GetString("wave")
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != len(waveNames) {
+		return &imageserver.ParamError{Param: "wave", Message: "must be \"<amplitude>,<wavelength>\""}
+	}
+	formatted := make([]string, 0, len(parts))
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return &imageserver.ParamError{Param: "wave", Message: fmt.Sprintf("parse %s: %s", waveNames[i], err)}
+		}
+		if f <= 0 {
+			return &imageserver.ParamError{Param: "wave", Message: fmt.Sprintf("%s must be greater than 0", waveNames[i])}
+		}
+		formatted = append(formatted, formatFloat(f))
+	}
+	err = hdr.checkDistortion("wave")
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-wave")
+	arguments.PushBack(strings.Join(formatted, "x"))
+	return nil
+}
+
+func (hdr *Handler) checkDistortion(name string) error {
+	if !hdr.AllowDistortions {
+		return &imageserver.ParamError{Param: name, Message: "distortions are not allowed"}
+	}
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsRoll(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("roll") {
+		return nil
+	}
+	s, err := params.GetString("roll")
+	if err != nil {
+		return err
+	}
+	x, y, err := parseOffset("roll", s)
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-roll")
+	arguments.PushBack(formatOffset(x, y))
+	return nil
+}
+
+// parseOffset parses a "<+/-x><+/-y>" (geometry) or "<x>,<y>" offset.
+func parseOffset(name string, s string) (x int, y int, err error) {
+	i := strings.IndexByte(s, ',')
+	if i < 0 && (strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-")) {
+		i = strings.IndexAny(s[1:], "+-")
+		if i >= 0 {
+			i++
+		}
+	}
+	if i < 0 {
+		return 0, 0, &imageserver.ParamError{Param: name, Message: "must be \"<+/-x><+/-y>\" or \"<x>,<y>\""}
+	}
+	xString, yString := s[:i], s[i:]
+	if s[i] == ',' {
+		yString = s[i+1:]
+	}
+	x, err = strconv.Atoi(xString)
+	if err != nil {
+		return 0, 0, &imageserver.ParamError{Param: name, Message: fmt.Sprintf("parse x: %s", err)}
+	}
+	y, err = strconv.Atoi(yString)
+	if err != nil {
+		return 0, 0, &imageserver.ParamError{Param: name, Message: fmt.Sprintf("parse y: %s", err)}
+	}
+	return x, y, nil
+}
+
+// formatOffset formats an offset as a geometry, with explicit signs (e.g. "+10-20").
+func formatOffset(x int, y int) string {
+	return fmt.Sprintf("%+d%+d", x, y)
+}
+
+// buildArgumentsFlatten must be called after buildArgumentsBackground, because "-flatten" uses the background color.
+func (hdr *Handler) buildArgumentsFlatten(arguments *list.List, params imageserver.Params, im *imageserver.Image) error {
+	flatten, err := hdr.getFlatten(params, im)
+	if err != nil || !flatten {
+		return err
+	}
+	if !params.Has("background") {
+		arguments.PushBack("-background")
+		arguments.PushBack("#" + defaultFlattenBackground)
+	}
+	arguments.PushBack("-flatten")
+	return nil
+}
+
+// getFlatten returns the "flatten" param, or true if a transparent source format is converted to jpeg or the alpha channel is removed.
+func (hdr *Handler) getFlatten(params imageserver.Params, im *imageserver.Image) (bool, error) {
+	alpha, err := getAlpha(params)
+	if err != nil {
+		return false, err
+	}
+	if alpha == "remove" {
+		if params.Has("flatten") {
+			return false, &imageserver.ParamError{Param: "flatten", Message: "can't be used with alpha \"remove\""}
+		}
+		return true, nil
+	}
+	if params.Has("flatten") {
+		return params.GetBool("flatten")
+	}
+	format, err := hdr.getFormat(params, im)
+	if err != nil {
+		return false, err
+	}
+	return format == "jpeg" && transparentFormats[im.Format], nil
+}
+
+// buildArgumentsShear must be called after buildArgumentsBackground.
+//
+// The "-background" argument is used to fill the corners, it is added with a white color if the "background" param is not set.
+func (hdr *Handler) buildArgumentsShear(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("shear") {
+		return nil
+	}
+	s, err := params.GetString("shear")
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > len(shearNames) {
+		return &imageserver.ParamError{Param: "shear", Message: "must be \"<x_degrees>\" or \"<x_degrees>,<y_degrees>\""}
+	}
+	formatted := make([]string, 0, len(parts))
+	for i, part := range parts {
+		angle, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return &imageserver.ParamError{Param: "shear", Message: fmt.Sprintf("parse %s: %s", shearNames[i], err)}
+		}
+		if angle < -maxShearAngle || angle > maxShearAngle {
+			return &imageserver.ParamError{Param: "shear", Message: fmt.Sprintf("%s must be between %d and %d", shearNames[i], -maxShearAngle, maxShearAngle)}
+		}
+		formatted = append(formatted, formatFloat(angle))
+	}
+	if !params.Has("background") {
+		arguments.PushBack("-background")
+		arguments.PushBack(fmt.Sprintf("#%s", defaultShearBackground))
+	}
+	arguments.PushBack("-shear")
+	arguments.PushBack(strings.Join(formatted, "x"))
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsBackground(arguments *list.List, params imageserver.Params) error {
+	if !params.Has("background") {
+		return nil
+	}
+	background, err := params.GetString("background")
+	if err != nil {
+		return err
+	}
+	err = validateColor("background", background)
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-background")
+	arguments.PushBack(fmt.Sprintf("#%s", background))
+	return nil
+}
+
+// validateColor validates a color: 3/4/6/8 lower case hexadecimal characters.
+func validateColor(name string, color string) error {
+	switch len(color) {
+	case 3, 4, 6, 8:
+	default:
+		return &imageserver.ParamError{Param: name, Message: "length must be equal to 3, 4, 6 or 8"}
+	}
+	for _, r := range color {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return &imageserver.ParamError{Param: name, Message: "must only contain characters in 0-9a-f"}
+		}
+	}
+	return nil
+}
+
+// buildArgumentsExtent uses the resize size (width/height) and the crop size (cropWidth/cropHeight, 0 if there is no crop).
+//
+// nolint: gocyclo
+func (hdr *Handler) buildArgumentsExtent(arguments *list.List, params imageserver.Params, im *imageserver.Image, width int, height int, cropWidth int, cropHeight int) error {
+	extent, err := getExtent(params)
+	if err != nil {
+		return err
+	}
+	if !extent {
+		for _, name := range []string{"extent_x", "extent_y"} {
+			if params.Has(name) {
+				return &imageserver.ParamError{Param: name, Message: "can't be used without extent or pad"}
+			}
+		}
+		return nil
+	}
+	if width == 0 && height == 0 {
+		return &imageserver.ParamError{Param: getExtentParam(params), Message: "requires width or height"}
+	}
+	if width == 0 || height == 0 {
+		width, height, err = getExtentSize(params, im, width, height, cropWidth, cropHeight)
+		if err != nil {
+			return err
+		}
+	}
+	x, y, err := getExtentOffset(params)
+	if err != nil {
+		return err
+	}
+	gravity, err := getGravity(params)
+	if err != nil {
+		return err
+	}
+	err = hdr.checkMaxSize(getExtentParam(params), width, height)
+	if err != nil {
+		return err
+	}
+	err = hdr.buildArgumentsPadBackground(arguments, params, im)
+	if err != nil {
+		return err
+	}
+	geometry := fmt.Sprintf("%dx%d", width, height)
+	if x != 0 || y != 0 {
+		geometry += formatOffset(x, y)
+	}
+	arguments.PushBack("-gravity")
+	arguments.PushBack(gravity)
+	arguments.PushBack("-extent")
+	arguments.PushBack(geometry)
+	return nil
+}
+
+// getExtentParam returns the name of the param that enables extent.
+func getExtentParam(params imageserver.Params) string {
+	for _, name := range []string{"fit", "pad"} {
+		if params.Has(name) {
+			return name
+		}
+	}
+	return "extent"
+}
+
+// buildArgumentsPadBackground adds the default background color for pad, if there is no "background" param.
+func (hdr *Handler) buildArgumentsPadBackground(arguments *list.List, params imageserver.Params, im *imageserver.Image) error {
+	if !params.Has("pad") || params.Has("background") {
+		return nil
+	}
+	format, err := hdr.getFormat(params, im)
+	if err != nil {
+		return err
+	}
+	arguments.PushBack("-background")
+	if format == "jpeg" {
+		arguments.PushBack("#" + defaultPadBackgroundJPEG)
+	} else {
+		arguments.PushBack("none")
+	}
+	return nil
+}
+
+// getExtentSize returns the extent size if only one of width/height is set.
+//
+// The other one is computed from the aspect ratio of the crop size, or the source Image.
+func getExtentSize(params imageserver.Params, im *imageserver.Image, width int, height int, cropWidth int, cropHeight int) (int, int, error) {
+	sourceWidth, sourceHeight, err := getBaseDimensions(params, im, cropWidth, cropHeight)
+	if err != nil {
+		return 0, 0, err
+	}
+	if width == 0 {
+		width = int(math.Round(float64(sourceWidth*height) / float64(sourceHeight)))
+	} else {
+		height = int(math.Round(float64(sourceHeight*width) / float64(sourceWidth)))
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height, nil
+}
+
+// getExtentOffset returns the "extent_x" and "extent_y" params (0 by default).
+func getExtentOffset(params imageserver.Params) (x int, y int, err error) {
+	if params.Has("extent_x") {
+		x, err = params.GetInt("extent_x")
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if params.Has("extent_y") {
+		y, err = params.GetInt("extent_y")
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return x, y, nil
+}
+
+// getExtent returns true if "-extent" is applied, from the "extent", "pad" or "fit" params.
+func getExtent(params imageserver.Params) (bool, error) {
+	fit, ok, err := getFitMode(params)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return fit.extent, nil
+	}
+	if params.Has("pad") {
+		if params.Has("extent") {
+			return false, &imageserver.ParamError{Param: "pad", Message: "can't be used with extent"}
+		}
+		return params.GetBool("pad")
+	}
+	if !params.Has("extent") {
+		return false, nil
+	}
+	return params.GetBool("extent")
+}
+
+// buildArgumentsBorder must be called after buildArgumentsResize and buildArgumentsExtent.
+//
+// The border size doesn't depend on the output image size.
+func (hdr *Handler) buildArgumentsBorder(arguments *list.List, params imageserver.Params) error {
+	borderColorParam, err := getBorderColorParam(params)
+	if err != nil {
+		return err
+	}
+	if !params.Has("border") {
+		if borderColorParam != "" {
+			return &imageserver.ParamError{Param: borderColorParam, Message: "can't be used without border"}
+		}
+		return nil
+	}
+	v, err := params.Get("border")
+	if err != nil {
+		return err
+	}
+	var border string
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return &imageserver.ParamError{Param: "border", Message: "must be greater than or equal to 0"}
+		}
+		border = fmt.Sprintf("%dx%d", v, v)
+	case string:
+		border, err = parseEdgeSize("border", v)
+		if err != nil {
+			return err
+		}
+	default:
+		return &imageserver.ParamError{Param: "border", Message: fmt.Sprintf("contains a value of type %T instead of int or string", v)}
+	}
+	borderColor := defaultBorderColor
+	if borderColorParam != "" {
+		borderColor, err = params.GetString(borderColorParam)
+		if err != nil {
+			return err
+		}
+		err = validateColor(borderColorParam, borderColor)
+		if err != nil {
+			return err
+		}
+	}
+	if border == "0x0" {
+		return nil
+	}
+	arguments.PushBack("-bordercolor")
+	arguments.PushBack(fmt.Sprintf("#%s", borderColor))
+	arguments.PushBack("-border")
+	arguments.PushBack(border)
+	return nil
+}
+
+// getBorderColorParam returns the name of the border color param ("border_color", or its alias "bordercolor"), or an empty string if none is set.
+func getBorderColorParam(params imageserver.Params) (string, error) {
+	if !params.Has("bordercolor") {
+		if params.Has("border_color") {
+			return "border_color", nil
+		}
+		return "", nil
+	}
+	if params.Has("border_color") {
+		return "", &imageserver.ParamError{Param: "bordercolor", Message: "can't be used with border_color"}
+	}
+	return "bordercolor", nil
+}
+
+// parseEdgeSize parses a "<size>" or "<width>x<height>" edge size value (border, shave).
+func parseEdgeSize(param string, s string) (string, error) {
+	parts := strings.Split(s, "x")
+	if len(parts) > 2 {
+		return "", &imageserver.ParamError{Param: param, Message: "expected format '<size>' or '<width>x<height>'"}
+	}
+	sizes := make([]int, 0, 2)
+	for _, part := range parts {
+		size, err := strconv.Atoi(part)
+		if err != nil {
+			return "", &imageserver.ParamError{Param: param, Message: fmt.Sprintf("parse int: %s", err)}
+		}
+		if size < 0 {
+			return "", &imageserver.ParamError{Param: param, Message: "must be greater than or equal to 0"}
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 1 {
+		sizes = append(sizes, sizes[0])
+	}
+	return fmt.Sprintf("%dx%d", sizes[0], sizes[1]), nil
+}
+
+// buildArgumentsStrip must be called after the other image operations.
+//
+// The "-strip" argument removes the EXIF profile, which is required by "-auto-orient".
+func (hdr *Handler) buildArgumentsStrip(arguments *list.List, params imageserver.Params) error {
+	strip := hdr.AlwaysStrip
+	if !strip && params.Has("strip") {
+		var err error
+		strip, err = params.GetBool("strip")
+		if err != nil {
+			return err
+		}
+	}
+	if !strip {
+		return nil
+	}
+	keep, err := getKeepProfiles(params)
+	if err != nil {
+		return err
+	}
+	if len(keep) == 0 {
+		arguments.PushBack("-strip")
+		return nil
+	}
+	// "-strip" removes all profiles, so the other profiles are removed one by one.
+	for _, profile := range profiles {
+		if keep[profile] {
+			continue
+		}
+		arguments.PushBack("+profile")
+		arguments.PushBack(profile)
+	}
+	return nil
+}
+
+// getKeepProfiles returns the profiles kept by the "keep_icc" and "keep_profiles" params.
+func getKeepProfiles(params imageserver.Params) (map[string]bool, error) {
+	keep := make(map[string]bool)
+	if params.Has("keep_icc") {
+		keepICC, err := params.GetBool("keep_icc")
+		if err != nil {
+			return nil, err
+		}
+		if keepICC {
+			keep["icc"] = true
+		}
+	}
+	if params.Has("keep_profiles") {
+		s, err := params.GetString("keep_profiles")
+		if err != nil {
+			return nil, err
+		}
+		for _, profile := range strings.Split(s, ",") {
+			if alias, ok := profileAliases[profile]; ok {
+				profile = alias
+			}
+			if !isProfile(profile) {
+				return nil, &imageserver.ParamError{Param: "keep_profiles", Message: fmt.Sprintf("unknown profile %q, must be one of %s (or icm)", profile, strings.Join(profiles, ", "))}
+			}
+			keep[profile] = true
+		}
+	}
+	return keep, nil
+}
+
+func isProfile(profile string) bool {
+	for _, p := range profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// getGravity returns the GraphicsMagick gravity from the "gravity" param.
+//
+// It accepts compass abbreviations ("n", "ne", ...) and full names ("north", "NorthEast", ...), case insensitive.
+// The default value is "Center".
+func getGravity(params imageserver.Params) (string, error) {
+	if !params.Has("gravity") {
+		return defaultGravity, nil
+	}
+	gravity, err := params.GetString("gravity")
+	if err != nil {
+		return "", err
+	}
+	if gravity == "" {
+		return defaultGravity, nil
+	}
+	g, ok := gravities[strings.ToLower(gravity)]
+	if !ok {
+		return "", &imageserver.ParamError{Param: "gravity", Message: "must be a compass direction (n, ne, e, se, s, sw, w, nw, c) or a full name (north, northeast, ..., center)"}
+	}
+	return g, nil
+}
+
+// buildArgumentsColors must be called before buildArgumentsFormat.
+//
+// "-dither" must be before "-colors", because it is used by the quantization.
+func (hdr *Handler) buildArgumentsColors(arguments *list.List, params imageserver.Params, sourceImage *imageserver.Image) error {
+	if !params.Has("colors") {
+		if params.Has("dither") {
+			return &imageserver.ParamError{Param: "dither", Message: "can't be used without colors"}
+		}
+		return nil
+	}
+	colors, err := params.GetInt("colors")
+	if err != nil {
+		return err
+	}
+	if colors < 2 || colors > 256 {
+		return &imageserver.ParamError{Param: "colors", Message: "must be between 2 and 256"}
+	}
+	format, err := hdr.getFormat(params, sourceImage)
+	if err != nil {
+		return err
+	}
+	if format == "jpeg" {
+		return &imageserver.ParamError{Param: "colors", Message: "can't be used with jpeg format, it doesn't support palette reduction"}
+	}
+	if params.Has("dither") {
+		dither, err := params.GetBool("dither")
+		if err != nil {
+			return err
+		}
+		if dither {
+			arguments.PushBack("-dither")
+		} else {
+			arguments.PushBack("+dither")
+		}
+	}
+	arguments.PushBack("-colors")
+	arguments.PushBack(strconv.Itoa(colors))
+	return nil
+}
+
+// buildArgumentsAlpha removes the alpha channel, the flatten for "remove" is done by buildArgumentsFlatten.
+func (hdr *Handler) buildArgumentsAlpha(arguments *list.List, params imageserver.Params) error {
+	alpha, err := getAlpha(params)
+	if err != nil || alpha == "keep" {
+		return err
+	}
+	if hdr.Backend == ImageMagick {
+		arguments.PushBack("-alpha")
+		arguments.PushBack("off")
+		return nil
+	}
+	arguments.PushBack("+matte")
+	return nil
+}
+
+// getAlpha returns the "alpha" param ("keep" by default).
+func getAlpha(params imageserver.Params) (string, error) {
+	if !params.Has("alpha") {
+		return "keep", nil
+	}
+	alpha, err := params.GetString("alpha")
+	if err != nil {
+		return "", err
+	}
+	if alpha != "keep" && alpha != "remove" && alpha != "opaque" {
+		return "", &imageserver.ParamError{Param: "alpha", Message: "must be keep, remove or opaque"}
+	}
+	return alpha, nil
+}
+
+// buildArgumentsFormat returns the output format, and the GraphicsMagick coder used to write it (e.g. "png8" for "png").
+func (hdr *Handler) buildArgumentsFormat(arguments *list.List, params imageserver.Params, sourceImage *imageserver.Image) (format string, coder string, formatSpecified bool, err error) {
+	format, err = hdr.getFormat(params, sourceImage)
+	if err != nil {
+		return "", "", false, err
+	}
+	png8, err := getPNG8(params, format)
+	if err != nil {
+		return "", "", false, err
+	}
+	coder = format
+	if png8 {
+		coder = "png8"
+	}
+	if !params.Has("format") && !png8 {
+		return format, coder, false, nil
+	}
+	if params.Has("format") && !hdr.isAllowedFormat(format) {
+		return "", "", false, &imageserver.ParamError{Param: "format", Message: "not allowed"}
+	}
+	arguments.PushBack("-format")
+	arguments.PushBack(coder)
+	return format, coder, true, nil
+}
+
+func (hdr *Handler) isAllowedFormat(format string) bool {
+	if hdr.AllowedFormats == nil {
+		return true
+	}
+	for _, f := range hdr.AllowedFormats {
+		if strings.EqualFold(f, format) {
+			return true
+		}
+	}
+	return false
+}
+
+func getPNG8(params imageserver.Params, format string) (bool, error) {
+	if !params.Has("png8") {
+		return false, nil
+	}
+	png8, err := params.GetBool("png8")
+	if err != nil {
+		return false, err
+	}
+	if png8 && format != "png" {
+		return false, &imageserver.ParamError{Param: "png8", Message: "requires png output format"}
+	}
+	return png8, nil
+}
+
+// coderFormat returns the Image format written by a GraphicsMagick coder.
+func coderFormat(coder string) string {
+	if f, ok := coderFormats[coder]; ok {
+		return f
+	}
+	return coder
+}
+
+// getFormat returns the output format: the normalized "format" param, or the source Image format.
+func (hdr *Handler) getFormat(params imageserver.Params, sourceImage *imageserver.Image) (string, error) {
+	if !params.Has("format") {
+		return sourceImage.Format, nil
+	}
+	format, err := params.GetString("format")
+	if err != nil {
+		return "", err
+	}
+	return hdr.normalizeFormat(format), nil
+}
+
+// normalizeFormat returns the lower case format, with the aliases replaced.
+func (hdr *Handler) normalizeFormat(format string) string {
+	format = strings.ToLower(format)
+	if alias, ok := hdr.FormatAliases[format]; ok {
+		return alias
+	}
+	if alias, ok := formatAliases[format]; ok {
+		return alias
+	}
+	return format
+}
+
+func (hdr *Handler) buildArgumentsQuality(arguments *list.List, params imageserver.Params, format string) error {
+	if format == "png" && params.Has("png_compression") {
+		// The quality is set by buildArgumentsPNGCompression.
+		return nil
+	}
+	quality := hdr.DefaultQuality[format]
+	if params.Has("quality") {
+		var err error
+		quality, err = params.GetInt("quality")
+		if err != nil {
+			return err
+		}
+	} else if quality == 0 {
+		return nil
+	}
+	if quality < 0 {
+		return &imageserver.ParamError{Param: "quality", Message: "must be greater than or equal to 0"}
+	}
+	if lossyFormats[format] && quality > 100 {
+		return &imageserver.ParamError{Param: "quality", Message: "must be between 0 and 100"}
+	}
+	arguments.PushBack("-quality")
+	arguments.PushBack(strconv.Itoa(quality))
+	return nil
+}
+
+// buildArgumentsSamplingFactor requires the jpeg output format.
+//
+// DefaultSamplingFactor is ignored for the other formats.
+func (hdr *Handler) buildArgumentsSamplingFactor(arguments *list.List, params imageserver.Params, format string) error {
+	var samplingFactor string
+	if params.Has("sampling_factor") {
+		s, err := params.GetString("sampling_factor")
+		if err != nil {
+			return err
+		}
+		var ok bool
+		samplingFactor, ok = samplingFactors[s]
+		if !ok {
+			return &imageserver.ParamError{Param: "sampling_factor", Message: "must be 4:4:4, 4:2:2 or 4:2:0"}
+		}
+		if format != "jpeg" {
+			return &imageserver.ParamError{Param: "sampling_factor", Message: "requires jpeg output format"}
+		}
+	} else {
+		if hdr.DefaultSamplingFactor == "" || format != "jpeg" {
+			return nil
+		}
+		var ok bool
+		samplingFactor, ok = samplingFactors[hdr.DefaultSamplingFactor]
+		if !ok {
+			return fmt.Errorf("invalid default sampling factor %q, must be 4:4:4, 4:2:2 or 4:2:0", hdr.DefaultSamplingFactor)
+		}
+	}
+	arguments.PushBack("-sampling-factor")
+	arguments.PushBack(samplingFactor)
+	return nil
+}
+
+func (hdr *Handler) buildArgumentsMaxBytes(arguments *list.List, params imageserver.Params, format string) error {
+	if !params.Has("max_bytes") {
+		return nil
+	}
+	maxBytes, err := params.GetInt("max_bytes")
+	if err != nil {
+		return err
+	}
+	if maxBytes < 1024 {
+		return &imageserver.ParamError{Param: "max_bytes", Message: "must be greater than or equal to 1024"}
+	}
+	if format != "jpeg" {
+		return nil
+	}
+	return buildArgumentsDefine(arguments, "jpeg", "extent", fmt.Sprintf("%dKB", maxBytes/1024))
+}
+
+func (hdr *Handler) buildArgumentsPNGCompression(arguments *list.List, params imageserver.Params, format string) error {
+	if !params.Has("png_compression") {
+		return nil
+	}
+	level, err := params.GetInt("png_compression")
+	if err != nil {
+		return err
+	}
+	if level < 0 || level > 9 {
+		return &imageserver.ParamError{Param: "png_compression", Message: "must be between 0 and 9"}
+	}
+	if format != "png" {
+		return &imageserver.ParamError{Param: "png_compression", Message: "requires png output format"}
+	}
+	if params.Has("quality") {
+		return &imageserver.ParamError{Param: "png_compression", Message: "can't be used with quality"}
+	}
+	if hdr.Backend == ImageMagick {
+		return buildArgumentsDefine(arguments, "png", "compression-level", strconv.Itoa(level))
+	}
+	arguments.PushBack("-quality")
+	arguments.PushBack(strconv.Itoa(pngCompressionQuality(level)))
+	return nil
+}
+
+// pngCompressionQuality converts a zlib compression level (0-9) to the GraphicsMagick PNG quality.
+//
+// For PNG, the quality tens digit is the zlib compression level (0 means Huffman only compression),
+// and the ones digit is the filter type (5 is adaptive filtering, the default).
+// E.g. level 9 is quality 95, level 0 is quality 5.
+func pngCompressionQuality(level int) int {
+	return level*10 + pngAdaptiveFilter
+}
+
+func (hdr *Handler) buildArgumentsWebP(arguments *list.List, params imageserver.Params, format string) error {
+	for _, name := range []string{"webp_lossless", "webp_alpha_quality"} {
+		if params.Has(name) && format != "webp" {
+			return &imageserver.ParamError{Param: name, Message: "requires webp output format"}
+		}
+	}
+	if params.Has("webp_lossless") {
+		lossless, err := params.GetBool("webp_lossless")
+		if err != nil {
+			return err
+		}
+		if lossless {
+			err = buildArgumentsDefine(arguments, "webp", "lossless", "true")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if params.Has("webp_alpha_quality") {
+		alphaQuality, err := params.GetInt("webp_alpha_quality")
+		if err != nil {
+			return err
+		}
+		if alphaQuality < 0 || alphaQuality > 100 {
+			return &imageserver.ParamError{Param: "webp_alpha_quality", Message: "must be between 0 and 100"}
+		}
+		err = buildArgumentsDefine(arguments, "webp", "alpha-quality", strconv.Itoa(alphaQuality))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildArgumentsDefine adds a "-define <format>:<name>=<value>" coder option.
+//
+// The format, name and value are validated, because they are given in a single argument.
+// The values come from validated params, so an error is an internal error.
+func buildArgumentsDefine(arguments *list.List, format string, name string, value string) error {
+	for _, s := range []string{format, name, value} {
+		if !isDefineToken(s) {
+			return fmt.Errorf("invalid define %q", fmt.Sprintf("%s:%s=%s", format, name, value))
+		}
+	}
+	arguments.PushBack("-define")
+	arguments.PushBack(fmt.Sprintf("%s:%s=%s", format, name, value))
+	return nil
+}
+
+// isDefineToken returns true if s is not empty and contains only ASCII letters, digits, "-" and ".".
+func isDefineToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
 }
 
-// Handle implements imageserver.Handler.
-func (hdr *Handler) Handle(im *imageserver.Image, params imageserver.Params) (*imageserver.Image, error) {
-	if !params.Has(param) {
-		return im, nil
+func (hdr *Handler) buildArgumentsDepth(arguments *list.List, params imageserver.Params) error {
+	depth := hdr.DefaultDepth
+	if params.Has("depth") {
+		var err error
+		depth, err = params.GetInt("depth")
+		if err != nil {
+			return err
+		}
+	} else if depth == 0 {
+		return nil
 	}
-	params, err := params.GetParams(param)
-	if err != nil {
-		return nil, err
+	if depth != 8 && depth != 16 {
+		return &imageserver.ParamError{Param: "depth", Message: "must be 8 or 16"}
 	}
-	if params.Empty() {
-		return im, nil
+	arguments.PushBack("-depth")
+	arguments.PushBack(strconv.Itoa(depth))
+	return nil
+}
+
+// buildArgumentsInterlace is opt-in: the output is not modified if the param (or DefaultInterlace) is not set.
+func (hdr *Handler) buildArgumentsInterlace(arguments *list.List, params imageserver.Params) error {
+	interlace, err := hdr.getInterlace(params)
+	if err != nil || interlace == "" {
+		return err
+	}
+	arguments.PushBack("-interlace")
+	arguments.PushBack(interlace)
+	return nil
+}
+
+func (hdr *Handler) getInterlace(params imageserver.Params) (string, error) {
+	if !params.Has("interlace") {
+		if hdr.DefaultInterlace == "" {
+			return "", nil
+		}
+		interlace, ok := findInterlace(hdr.DefaultInterlace)
+		if !ok {
+			return "", fmt.Errorf("invalid default interlace %q, must be one of %s", hdr.DefaultInterlace, strings.Join(interlaces, ", "))
+		}
+		return interlace, nil
 	}
-	im, err = hdr.handle(im, params)
+	v, err := params.Get("interlace")
 	if err != nil {
-		if err, ok := err.(*imageserver.ParamError); ok {
-			err.Param = param + "." + err.Param
+		return "", err
+	}
+	switch v := v.(type) {
+	case bool:
+		if !v {
+			return "", nil
 		}
-		return nil, err
+		return defaultInterlace, nil
+	case string:
+		interlace, ok := findInterlace(v)
+		if !ok {
+			return "", &imageserver.ParamError{Param: "interlace", Message: fmt.Sprintf("must be one of %s", strings.Join(interlaces, ", "))}
+		}
+		return interlace, nil
+	default:
+		return "", &imageserver.ParamError{Param: "interlace", Message: fmt.Sprintf("contains a value of type %T instead of bool or string", v)}
 	}
-	return im, nil
 }
 
-// nolint: gocyclo
-func (hdr *Handler) handle(im *imageserver.Image, params imageserver.Params) (*imageserver.Image, error) {
-	arguments := list.New()
+// findInterlace returns the interlace name matching s (case insensitive).
+func findInterlace(s string) (string, bool) {
+	for _, interlace := range interlaces {
+		if strings.EqualFold(s, interlace) {
+			return interlace, true
+		}
+	}
+	return "", false
+}
 
-	width, height, err := hdr.buildArgumentsResize(arguments, params)
-	if err != nil {
-		return nil, err
+// buildStepSRGB returns an input step that converts the Image to sRGB, if the "srgb" param (or ConvertToSRGB) is enabled.
+//
+// It runs before the main command, so the embedded ICC profile is still present (it could be removed by strip or resize_mode "thumbnail").
+func (hdr *Handler) buildStepSRGB(params imageserver.Params) (step, error) {
+	srgb := hdr.ConvertToSRGB
+	if params.Has("srgb") {
+		var err error
+		srgb, err = params.GetBool("srgb")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !srgb {
+		return nil, nil
 	}
+	return hdr.convertToSRGB, nil
+}
 
-	err = hdr.buildArgumentsBackground(arguments, params)
+// convertToSRGB converts the Image to sRGB, and removes the embedded ICC profile.
+//
+// If the Image has no ICC profile, it is assumed to be sRGB and is not modified.
+func (hdr *Handler) convertToSRGB(ctx context.Context, tempDir string, file string) error {
+	profile := filepath.Join(tempDir, "srgb.icc")
+	err := ioutil.WriteFile(profile, hdr.getSRGBProfile(), os.FileMode(0600))
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return hdr.runArguments(ctx, buildSRGBArguments(profile, file)...)
+}
 
-	err = hdr.buildArgumentsExtent(arguments, params, width, height)
-	if err != nil {
-		return nil, err
+// buildSRGBArguments returns the arguments that convert the file to the sRGB profile, then remove the ICC profile.
+func buildSRGBArguments(profile string, file string) []string {
+	return []string{"mogrify", "-profile", profile, "+profile", "icc", file}
+}
+
+//go:generate go run srgb_profile_gen.go
+
+// getSRGBProfile returns SRGBProfile, or the bundled sRGB profile if it is not set.
+func (hdr *Handler) getSRGBProfile() []byte {
+	if len(hdr.SRGBProfile) != 0 {
+		return hdr.SRGBProfile
 	}
+	return srgbProfile
+}
 
-	format, formatSpecified, err := hdr.buildArgumentsFormat(arguments, params, im)
+// buildArgumentsVignette must be called after buildArgumentsResize and buildArgumentsBorder, because the vignette depends on the output image dimensions.
+//
+// It is only used by the ImageMagick Backend, see buildStepVignette for GraphicsMagick.
+func (hdr *Handler) buildArgumentsVignette(arguments *list.List, params imageserver.Params) error {
+	if hdr.Backend != ImageMagick {
+		return nil
+	}
+	if params.Has("vignette_strength") {
+		return &imageserver.ParamError{Param: "vignette_strength", Message: "not supported by ImageMagick, use a '<radius>x<sigma>[+<x>+<y>]' vignette"}
+	}
+	if !params.Has("vignette") {
+		return nil
+	}
+	v, err := params.Get("vignette")
 	if err != nil {
-		return nil, err
+		return err
+	}
+	var vignette string
+	switch v := v.(type) {
+	case bool:
+		if !v {
+			return nil
+		}
+		vignette = defaultVignette
+	case string:
+		vignette, err = parseVignette(v)
+		if err != nil {
+			return err
+		}
+	default:
+		return &imageserver.ParamError{Param: "vignette", Message: fmt.Sprintf("contains a value of type %T instead of bool or string", v)}
 	}
+	arguments.PushBack("-vignette")
+	arguments.PushBack(vignette)
+	return nil
+}
 
-	err = hdr.buildArgumentsQuality(arguments, params, format)
+// parseVignette parses a "<radius>x<sigma>[+<x>+<y>]" value, and returns it normalized.
+func parseVignette(s string) (string, error) {
+	parts := strings.Split(s, "+")
+	if (len(parts) != 1 && len(parts) != 3) || !strings.ContainsAny(parts[0], radiusSigmaSeparators) {
+		return "", &imageserver.ParamError{Param: "vignette", Message: "expected format '<radius>x<sigma>[+<x>+<y>]'"}
+	}
+	radius, sigma, err := parseRadiusSigma("vignette", parts[0])
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	if arguments.Len() == 0 {
-		return im, nil
+	res := formatRadiusSigma(radius, sigma)
+	for i, part := range parts[1:] {
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return "", &imageserver.ParamError{Param: "vignette", Message: fmt.Sprintf("parse %s: %s", cropNames[i+2], err)}
+		}
+		if v < 0 {
+			return "", &imageserver.ParamError{Param: "vignette", Message: fmt.Sprintf("%s must be greater than or equal to 0", cropNames[i+2])}
+		}
+		res += "+" + strconv.Itoa(v)
 	}
+	return res, nil
+}
 
-	arguments.PushFront("mogrify")
+// buildStepVignette returns a step that darkens the edges of the image.
+//
+// GraphicsMagick doesn't support "-vignette", so it generates a blurred elliptical mask with the size of the output image,
+// and composites it over the output image with the "multiply" operator.
+// The ImageMagick Backend uses "-vignette" instead, see buildArgumentsVignette.
+func (hdr *Handler) buildStepVignette(params imageserver.Params) (step, error) {
+	if hdr.Backend == ImageMagick {
+		return nil, nil
+	}
+	vignette := false
+	if params.Has("vignette") {
+		if _, err := params.GetString("vignette"); err == nil {
+			return nil, &imageserver.ParamError{Param: "vignette", Message: "geometry not supported by GraphicsMagick, requires the ImageMagick Backend"}
+		}
+		var err error
+		vignette, err = params.GetBool("vignette")
+		if err != nil {
+			return nil, err
+		}
+	}
+	strength := defaultVignetteStrength
+	if params.Has("vignette_strength") {
+		if !vignette {
+			return nil, &imageserver.ParamError{Param: "vignette_strength", Message: "can't be used without vignette"}
+		}
+		var err error
+		strength, err = params.GetInt("vignette_strength")
+		if err != nil {
+			return nil, err
+		}
+		if strength < 0 || strength > 100 {
+			return nil, &imageserver.ParamError{Param: "vignette_strength", Message: "must be between 0 and 100"}
+		}
+	}
+	if !vignette {
+		return nil, nil
+	}
+	return func(ctx context.Context, tempDir string, file string) error {
+		return hdr.vignette(ctx, tempDir, file, strength)
+	}, nil
+}
 
-	tempDir, err := ioutil.TempDir(hdr.TempDir, tempDirPrefix)
+func (hdr *Handler) vignette(ctx context.Context, tempDir string, file string, strength int) error {
+	data, err := ioutil.ReadFile(file)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer func() {
-		_ = os.RemoveAll(tempDir)
-	}()
-
-	file := filepath.Join(tempDir, "image")
-	arguments.PushBack(file)
-	err = ioutil.WriteFile(file, im.Data, os.FileMode(0600))
+	width, height, err := decodeDimensions(data)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	argumentSlice := convertArgumentsToSlice(arguments)
-	cmd := exec.Command(hdr.Executable, argumentSlice...)
-	err = hdr.runCommand(cmd)
+	mask := filepath.Join(tempDir, "vignette_mask.png")
+	err = hdr.runArguments(ctx, buildVignetteMaskArguments(width, height, strength, mask)...)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return hdr.runArguments(ctx, "composite", "-compose", "Multiply", mask, file, file)
+}
 
-	if formatSpecified {
-		file = fmt.Sprintf("%s.%s", file, format)
+func buildVignetteMaskArguments(width int, height int, strength int, mask string) []string {
+	edge := 255 * (100 - strength) / 100
+	sigma := width
+	if height < sigma {
+		sigma = height
 	}
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		return nil, err
+	sigma /= 8
+	if sigma == 0 {
+		sigma = 1
 	}
-
-	im = &imageserver.Image{
-		Format: format,
-		Data:   data,
+	return []string{
+		"convert",
+		"-size", fmt.Sprintf("%dx%d", width, height),
+		fmt.Sprintf("xc:#%02x%02x%02x", edge, edge, edge),
+		"-fill", "white",
+		"-draw", fmt.Sprintf("ellipse %d,%d %d,%d 0,360", width/2, height/2, width*4/10, height*4/10),
+		"-blur", fmt.Sprintf("0x%d", sigma),
+		mask,
 	}
-	return im, nil
 }
 
-// nolint: gocyclo
-func (hdr *Handler) buildArgumentsResize(arguments *list.List, params imageserver.Params) (width int, height int, err error) {
-	width, err = getDimension("width", params)
+type watermark struct {
+	source   string
+	gravity  string
+	dissolve int
+	x        int
+	y        int
+}
+
+// buildStepWatermark returns a step that composites a watermark Image over the output Image.
+//
+// The watermark Image is returned by WatermarkServer.
+func (hdr *Handler) buildStepWatermark(params imageserver.Params) (step, error) {
+	if !params.Has("watermark") {
+		return nil, nil
+	}
+	if hdr.WatermarkServer == nil {
+		return nil, &imageserver.ParamError{Param: "watermark", Message: "not supported"}
+	}
+	params, err := params.GetParams("watermark")
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
-	height, err = getDimension("height", params)
+	wm, err := parseWatermark(params)
 	if err != nil {
-		return 0, 0, err
+		if err, ok := err.(*imageserver.ParamError); ok {
+			err.Param = "watermark." + err.Param
+		}
+		return nil, err
 	}
-	if width == 0 && height == 0 {
-		return 0, 0, nil
+	return func(ctx context.Context, tempDir string, file string) error {
+		return hdr.compositeWatermark(ctx, tempDir, file, wm)
+	}, nil
+}
+
+func parseWatermark(params imageserver.Params) (*watermark, error) {
+	wm := &watermark{
+		dissolve: 100,
 	}
-	widthString := ""
-	if width != 0 {
-		widthString = strconv.Itoa(width)
+	var err error
+	wm.source, err = params.GetString(imageserver_source.Param)
+	if err != nil {
+		return nil, err
 	}
-	heightString := ""
-	if height != 0 {
-		heightString = strconv.Itoa(height)
+	wm.gravity, err = getGravity(params)
+	if err != nil {
+		return nil, err
 	}
-	resize := fmt.Sprintf("%sx%s", widthString, heightString)
-	if params.Has("fill") {
-		fill, err := params.GetBool("fill")
+	if params.Has("dissolve") {
+		wm.dissolve, err = params.GetInt("dissolve")
 		if err != nil {
-			return 0, 0, err
+			return nil, err
 		}
-		if fill {
-			resize = resize + "^"
+		if wm.dissolve < 0 || wm.dissolve > 100 {
+			return nil, &imageserver.ParamError{Param: "dissolve", Message: "must be between 0 and 100"}
 		}
 	}
-	if params.Has("ignore_ratio") {
-		ignoreRatio, err := params.GetBool("ignore_ratio")
+	if params.Has("x") {
+		wm.x, err = params.GetInt("x")
 		if err != nil {
-			return 0, 0, err
-		}
-		if ignoreRatio {
-			resize = resize + "!"
+			return nil, err
 		}
 	}
-	if params.Has("only_shrink_larger") {
-		onlyShrinkLarger, err := params.GetBool("only_shrink_larger")
+	if params.Has("y") {
+		wm.y, err = params.GetInt("y")
 		if err != nil {
-			return 0, 0, err
-		}
-		if onlyShrinkLarger {
-			resize = resize + ">"
+			return nil, err
 		}
 	}
-	if params.Has("only_enlarge_smaller") {
-		onlyEnlargeSmaller, err := params.GetBool("only_enlarge_smaller")
-		if err != nil {
-			return 0, 0, err
-		}
-		if onlyEnlargeSmaller {
-			resize = resize + "<"
+	return wm, nil
+}
+
+// compositeWatermark writes the watermark Image to the temp directory, and runs the "composite" command.
+func (hdr *Handler) compositeWatermark(ctx context.Context, tempDir string, file string, wm *watermark) error {
+	im, err := hdr.WatermarkServer.Get(imageserver.Params{imageserver_source.Param: wm.source})
+	if err != nil {
+		if err, ok := err.(*imageserver.ParamError); ok {
+			err.Param = "watermark." + err.Param
 		}
+		return err
 	}
-	arguments.PushBack("-resize")
-	arguments.PushBack(resize)
-	return width, height, nil
+	watermarkFile := filepath.Join(tempDir, "watermark")
+	err = ioutil.WriteFile(watermarkFile, im.Data, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	return hdr.runArguments(ctx, buildWatermarkArguments(wm, watermarkFile, file)...)
 }
 
-func getDimension(name string, params imageserver.Params) (int, error) {
-	if !params.Has(name) {
-		return 0, nil
+func buildWatermarkArguments(wm *watermark, watermarkFile string, file string) []string {
+	arguments := []string{
+		"composite",
+		"-gravity", wm.gravity,
+		"-geometry", formatOffset(wm.x, wm.y),
 	}
-	dimension, err := params.GetInt(name)
-	if err != nil {
-		return 0, err
+	if wm.dissolve != 100 {
+		arguments = append(arguments, "-dissolve", strconv.Itoa(wm.dissolve))
 	}
-	if dimension < 0 {
-		return 0, &imageserver.ParamError{Param: name, Message: "must be greater than or equal to 0"}
+	return append(arguments, watermarkFile, file, file)
+}
+
+// decodeDimensions returns the dimensions of an encoded image, by parsing its header.
+func decodeDimensions(data []byte) (width int, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, &imageserver.ImageError{Message: fmt.Sprintf("decode dimensions: %s", err)}
 	}
-	return dimension, nil
+	return cfg.Width, cfg.Height, nil
 }
 
-func (hdr *Handler) buildArgumentsBackground(arguments *list.List, params imageserver.Params) error {
-	if !params.Has("background") {
-		return nil
+func convertArgumentsToSlice(arguments *list.List) []string {
+	argumentSlice := make([]string, 0, arguments.Len())
+	for e := arguments.Front(); e != nil; e = e.Next() {
+		argumentSlice = append(argumentSlice, e.Value.(string))
 	}
-	background, err := params.GetString("background")
+	return argumentSlice
+}
+
+func (hdr *Handler) runArguments(ctx context.Context, arguments ...string) error {
+	cmd, err := hdr.command(ctx, arguments)
 	if err != nil {
 		return err
 	}
-	switch len(background) {
-	case 3, 4, 6, 8:
-	default:
-		return &imageserver.ParamError{Param: "background", Message: "length must be equal to 3, 4, 6 or 8"}
+	return hdr.runCommand(ctx, cmd)
+}
+
+// command returns a command for the given arguments.
+//
+// The first argument is the GraphicsMagick command ("mogrify", "convert", ...), the resource limits are inserted after it.
+// The ImageMagick backend doesn't use the "convert" command, the arguments are given to "magick" directly.
+func (hdr *Handler) command(ctx context.Context, arguments []string) (*exec.Cmd, error) {
+	limits, err := hdr.buildResourceLimitArguments()
+	if err != nil {
+		return nil, err
 	}
-	for _, r := range background {
-		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
-			return &imageserver.ParamError{Param: "background", Message: "must only contain characters in 0-9a-f"}
+	if len(arguments) > 0 {
+		a := make([]string, 0, len(arguments)+len(limits))
+		if hdr.Backend != ImageMagick || arguments[0] != "convert" {
+			a = append(a, arguments[0])
 		}
+		a = append(a, limits...)
+		arguments = append(a, arguments[1:]...)
 	}
-	arguments.PushBack("-background")
-	arguments.PushBack(fmt.Sprintf("#%s", background))
-	return nil
+	return exec.CommandContext(ctx, hdr.Executable, arguments...), nil
 }
 
-func (hdr *Handler) buildArgumentsExtent(arguments *list.List, params imageserver.Params, width int, height int) error {
-	if width == 0 || height == 0 {
-		return nil
-	}
-	if !params.Has("extent") {
-		return nil
-	}
-	extent, err := params.GetBool("extent")
-	if err != nil {
-		return err
-	}
-	if extent {
-		arguments.PushBack("-gravity")
-		arguments.PushBack("center")
-		arguments.PushBack("-extent")
-		arguments.PushBack(fmt.Sprintf("%dx%d", width, height))
+func (hdr *Handler) resourceLimitNames() map[string]bool {
+	if hdr.Backend == ImageMagick {
+		return imageMagickResourceLimitNames
 	}
-	return nil
+	return resourceLimitNames
 }
 
-func (hdr *Handler) buildArgumentsFormat(arguments *list.List, params imageserver.Params, sourceImage *imageserver.Image) (format string, formatSpecified bool, err error) {
-	if !params.Has("format") {
-		return sourceImage.Format, false, nil
+// getResourceLimits returns the ResourceLimits by name.
+func (hdr *Handler) getResourceLimits() (map[string]string, error) {
+	pixelsName, threadsName := "pixels", "threads"
+	if hdr.Backend == ImageMagick {
+		pixelsName, threadsName = "area", "thread"
 	}
-	format, err = params.GetString("format")
-	if err != nil {
-		return "", false, err
+	limits := make(map[string]string, len(hdr.ResourceLimits.Others)+5)
+	for _, l := range []struct {
+		name  string
+		value string
+	}{
+		{"memory", hdr.ResourceLimits.Memory},
+		{"map", hdr.ResourceLimits.Map},
+		{"disk", hdr.ResourceLimits.Disk},
+		{pixelsName, hdr.ResourceLimits.Pixels},
+		{threadsName, hdr.ResourceLimits.Threads},
+	} {
+		if l.value != "" {
+			limits[l.name] = l.value
+		}
 	}
-	if hdr.AllowedFormats != nil {
-		ok := false
-		for _, f := range hdr.AllowedFormats {
-			if f == format {
-				ok = true
-				break
-			}
+	for name, value := range hdr.ResourceLimits.Others {
+		if !hdr.resourceLimitNames()[name] {
+			return nil, fmt.Errorf("unknown GraphicsMagick resource limit %q", name)
 		}
-		if !ok {
-			return "", false, &imageserver.ParamError{Param: "format", Message: "not allowed"}
+		if value == "" {
+			return nil, fmt.Errorf("empty GraphicsMagick resource limit %q", name)
 		}
+		limits[name] = value
 	}
-	arguments.PushBack("-format")
-	arguments.PushBack(format)
-	return format, true, nil
+	return limits, nil
 }
 
-func (hdr *Handler) buildArgumentsQuality(arguments *list.List, params imageserver.Params, format string) error {
-	if !params.Has("quality") {
-		return nil
-	}
-	quality, err := params.GetInt("quality")
-	if err != nil {
-		return err
+// buildResourceLimitArguments returns the "-limit" arguments for ResourceLimits, sorted by name.
+func (hdr *Handler) buildResourceLimitArguments() ([]string, error) {
+	resourceLimits, err := hdr.getResourceLimits()
+	if err != nil || len(resourceLimits) == 0 {
+		return nil, err
 	}
-	if quality < 0 {
-		return &imageserver.ParamError{Param: "quality", Message: "must be greater than or equal to 0"}
+	names := make([]string, 0, len(resourceLimits))
+	for name := range resourceLimits {
+		names = append(names, name)
 	}
-	if format == "jpeg" {
-		if quality < 0 || quality > 100 {
-			return &imageserver.ParamError{Param: "quality", Message: "must be between 0 and 100"}
+	sort.Strings(names)
+	limits := make([]string, 0, len(names)*3)
+	for _, name := range names {
+		value := resourceLimits[name]
+		if !isResourceLimitValue(value) {
+			return nil, fmt.Errorf("invalid GraphicsMagick resource limit %q value %q", name, value)
 		}
+		limits = append(limits, "-limit", name, value)
 	}
-	arguments.PushBack("-quality")
-	arguments.PushBack(strconv.Itoa(quality))
-	return nil
+	return limits, nil
 }
 
-func convertArgumentsToSlice(arguments *list.List) []string {
-	argumentSlice := make([]string, 0, arguments.Len())
-	for e := arguments.Front(); e != nil; e = e.Next() {
-		argumentSlice = append(argumentSlice, e.Value.(string))
+// isResourceLimitValue returns true if s is a positive number, optionally followed by a unit ("256MB", "1GiB", "4").
+func isResourceLimitValue(s string) bool {
+	if strings.HasSuffix(s, "iB") {
+		s = strings.TrimSuffix(s, "iB")
+	} else {
+		s = strings.TrimSuffix(s, "B")
 	}
-	return argumentSlice
+	if s != "" && strings.ContainsAny(s[len(s)-1:], "KMGTPEkmgtpe") {
+		s = s[:len(s)-1]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return err == nil && f >= 0 && !math.IsInf(f, 0)
+}
+
+// runCommand runs a command created with exec.CommandContext, so it is killed if the context is done.
+func (hdr *Handler) runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	start := time.Now()
+	err := hdr.runCommandWait(ctx, cmd)
+	if hdr.CommandFunc != nil {
+		hdr.CommandFunc(time.Since(start), err)
+	}
+	return err
 }
 
-func (hdr *Handler) runCommand(cmd *exec.Cmd) error {
+func (hdr *Handler) runCommandWait(ctx context.Context, cmd *exec.Cmd) error {
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
 	err := cmd.Start()
 	if err != nil {
 		return err
 	}
-	cmdChan := make(chan error, 1)
-	go func() {
-		cmdChan <- cmd.Wait()
-	}()
-	var timeoutChan <-chan time.Time
-	if hdr.Timeout != 0 {
-		timeoutChan = time.After(hdr.Timeout)
-	}
-	select {
-	case err = <-cmdChan:
-	case <-timeoutChan:
-		_ = cmd.Process.Kill()
-		err = fmt.Errorf("timeout after %s", hdr.Timeout)
-	}
+	err = cmd.Wait()
 	if err != nil {
-		return &imageserver.ImageError{Message: fmt.Sprintf("GraphicsMagick command: %s", err)}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+			if ctxErr == context.DeadlineExceeded && hdr.Timeout != 0 {
+				err = fmt.Errorf("timeout after %s", hdr.Timeout)
+			}
+		}
+		msg := fmt.Sprintf("GraphicsMagick command: %s", err)
+		if stderr.Len() > 0 {
+			msg = fmt.Sprintf("%s: %s", msg, formatStderr(stderr.Bytes()))
+		}
+		return &imageserver.ImageError{Message: msg}
 	}
 	return nil
 }
+
+// formatStderr returns the trimmed stderr output, truncated to maxStderrSize.
+func formatStderr(stderr []byte) string {
+	stderr = bytes.TrimSpace(stderr)
+	if len(stderr) > maxStderrSize {
+		return string(stderr[:maxStderrSize]) + "... (truncated)"
+	}
+	return string(stderr)
+}
@@ -1,7 +1,25 @@
 package graphicsmagick
 
 import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,7 +27,10 @@ import (
 	"github.com/pierrre/imageserver/testdata"
 )
 
-const testExecutable = "gm"
+const (
+	testExecutable            = "gm"
+	testImageMagickExecutable = "magick"
+)
 
 var _ imageserver.Handler = &Handler{}
 
@@ -30,6 +51,370 @@ func TestHandle(t *testing.T) {
 	}
 }
 
+func TestHandleStrip(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	for _, tc := range []struct {
+		name        string
+		params      imageserver.Params
+		expectedICC bool
+	}{
+		{
+			name:   "Default",
+			params: imageserver.Params{"strip": true},
+		},
+		{
+			name:        "KeepICC",
+			params:      imageserver.Params{"strip": true, "keep_icc": true},
+			expectedICC: true,
+		},
+		{
+			name:        "KeepProfilesICC",
+			params:      imageserver.Params{"strip": true, "keep_profiles": "icc"},
+			expectedICC: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			im, err := hdr.Handle(testdata.Medium, imageserver.Params{param: tc.params})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(im.Data, []byte("Exif\x00\x00")) {
+				t.Fatal("EXIF marker found")
+			}
+			if bytes.Contains(im.Data, []byte("ICC_PROFILE")) != tc.expectedICC {
+				t.Fatalf("unexpected ICC profile presence: got %t, want %t", !tc.expectedICC, tc.expectedICC)
+			}
+		})
+	}
+}
+
+func TestHandleAlwaysStripNoParams(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable:  testExecutable,
+		AlwaysStrip: true,
+	}
+	for _, params := range []imageserver.Params{
+		{},
+		{param: imageserver.Params{}},
+	} {
+		im, err := hdr.Handle(testdata.Medium, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(im.Data, []byte("Exif\x00\x00")) {
+			t.Fatal("EXIF marker found")
+		}
+	}
+}
+
+func TestHandleSepia(t *testing.T) {
+	testCheckAvailableImageMagick(t)
+	hdr := &Handler{
+		Executable: testImageMagickExecutable,
+		Backend:    ImageMagick,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+			"sepia": true,
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleSolarize(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width":    100,
+			"solarize": 40,
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleLevel(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+			"level": "5%,1.2,95%",
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleColors(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	for _, colors := range []int{2, 256} {
+		t.Run(strconv.Itoa(colors), func(t *testing.T) {
+			params := imageserver.Params{
+				param: imageserver.Params{
+					"width":  100,
+					"colors": colors,
+					"dither": false,
+					"format": "png",
+				},
+			}
+			im, err := hdr.Handle(testdata.Medium, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if im.Format != "png" {
+				t.Fatalf("unexpected format: got %s, want %s", im.Format, "png")
+			}
+		})
+	}
+}
+
+func TestHandleSamplingFactor(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	sizes := make(map[string]int)
+	for _, samplingFactor := range []string{"4:4:4", "4:2:0"} {
+		params := imageserver.Params{
+			param: imageserver.Params{
+				"format":          "jpeg",
+				"quality":         90,
+				"sampling_factor": samplingFactor,
+			},
+		}
+		im, err := hdr.Handle(testdata.Medium, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[samplingFactor] = len(im.Data)
+	}
+	if sizes["4:2:0"] >= sizes["4:4:4"] {
+		t.Fatalf("4:2:0 size (%d) is not lower than 4:4:4 size (%d)", sizes["4:2:0"], sizes["4:4:4"])
+	}
+}
+
+func TestHandleTransposeRotate(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	for _, tc := range []struct {
+		name           string
+		params         imageserver.Params
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{
+			name:           "Transpose",
+			params:         imageserver.Params{"transpose": true},
+			expectedWidth:  819,
+			expectedHeight: 1024,
+		},
+		{
+			name:           "TransverseRotate",
+			params:         imageserver.Params{"transverse": true, "rotate": 90.0},
+			expectedWidth:  1024,
+			expectedHeight: 819,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			im, err := hdr.Handle(testdata.Medium, imageserver.Params{param: tc.params})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(im.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != tc.expectedWidth || cfg.Height != tc.expectedHeight {
+				t.Fatalf("unexpected size: got %dx%d, want %dx%d", cfg.Width, cfg.Height, tc.expectedWidth, tc.expectedHeight)
+			}
+		})
+	}
+}
+
+func TestHandleFlatten(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	for _, tc := range []struct {
+		name          string
+		params        imageserver.Params
+		expectedColor color.Color
+	}{
+		{
+			name:          "Default",
+			params:        imageserver.Params{"format": "jpeg"},
+			expectedColor: color.White,
+		},
+		{
+			name:          "Background",
+			params:        imageserver.Params{"format": "jpeg", "background": "ff0000"},
+			expectedColor: color.RGBA{R: 0xff, A: 0xff},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			im, err := hdr.Handle(testNewTransparentPNG(t), imageserver.Params{param: tc.params})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if im.Format != "jpeg" {
+				t.Fatalf("unexpected format: got %s, want %s", im.Format, "jpeg")
+			}
+			nim, _, err := image.Decode(bytes.NewReader(im.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !testColorNear(nim.At(0, 0), tc.expectedColor) {
+				t.Fatalf("unexpected corner color: got %v, want %v", nim.At(0, 0), tc.expectedColor)
+			}
+		})
+	}
+}
+
+func TestHandleAlpha(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	for _, tc := range []struct {
+		name          string
+		alpha         string
+		expectedMatte string
+	}{
+		{
+			name:          "Keep",
+			alpha:         "keep",
+			expectedMatte: "True",
+		},
+		{
+			name:          "Remove",
+			alpha:         "remove",
+			expectedMatte: "False",
+		},
+		{
+			name:          "Opaque",
+			alpha:         "opaque",
+			expectedMatte: "False",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := imageserver.Params{param: imageserver.Params{"alpha": tc.alpha, "format": "png"}}
+			im, err := hdr.Handle(testNewTransparentPNG(t), params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command(testExecutable, "identify", "-format", "%A", "-")
+			cmd.Stdin = bytes.NewReader(im.Data)
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			matte := strings.TrimSpace(string(out))
+			if matte != tc.expectedMatte {
+				t.Fatalf("unexpected matte: got %q, want %q", matte, tc.expectedMatte)
+			}
+		})
+	}
+}
+
+// testNewTransparentPNG returns a PNG Image, with transparent borders around an opaque black square.
+func testNewTransparentPNG(tb testing.TB) *imageserver.Image {
+	nim := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(nim, image.Rect(4, 4, 12, 12), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, nim)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &imageserver.Image{Format: "png", Data: buf.Bytes()}
+}
+
+// testColorNear returns true if the colors are nearly the same (jpeg is lossy).
+func testColorNear(c1, c2 color.Color) bool {
+	r1, g1, b1, _ := c1.RGBA()
+	r2, g2, b2, _ := c2.RGBA()
+	for _, d := range []int64{int64(r1) - int64(r2), int64(g1) - int64(g2), int64(b1) - int64(b2)} {
+		if d < -0x1000 || d > 0x1000 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHandleCharcoalEmboss(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width":      100,
+			"colorspace": "GRAY",
+			"charcoal":   1.0,
+			"emboss":     1.0,
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleUseStdio(t *testing.T) {
+	testCheckAvailable(t)
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width":  100,
+			"height": 100,
+		},
+	}
+	for _, format := range []string{"", "png"} {
+		t.Run(format, func(t *testing.T) {
+			params := params.Copy()
+			if format != "" {
+				params.Set(param, imageserver.Params{"width": 100, "height": 100, "format": format})
+			}
+			imTempFile, err := (&Handler{Executable: testExecutable}).Handle(testdata.Medium, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imStdio, err := (&Handler{Executable: testExecutable, UseStdio: true}).Handle(testdata.Medium, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imStdio.Format != imTempFile.Format {
+				t.Fatalf("unexpected format: got %s, want %s", imStdio.Format, imTempFile.Format)
+			}
+			if !bytes.Equal(imStdio.Data, imTempFile.Data) {
+				t.Fatal("different data")
+			}
+		})
+	}
+}
+
 func TestHandleErrorTimeout(t *testing.T) {
 	testCheckAvailable(t)
 	hdr := &Handler{
@@ -51,9 +436,5274 @@ func TestHandleErrorTimeout(t *testing.T) {
 	}
 }
 
-func testCheckAvailable(tb testing.TB) {
-	_, err := exec.LookPath(testExecutable)
-	if err != nil {
-		tb.Skipf("GraphicsMagick is not available: %s", err)
+// buildArgumentsTestCase is a test case for Handler.buildArguments, see testBuildArguments.
+type buildArgumentsTestCase struct {
+	name               string
+	handler            *Handler
+	params             imageserver.Params
+	expectedArguments  []string
+	expectedParamError string
+}
+
+// testBuildArguments runs the test cases with the Image testdata.Medium, and a default Handler if the test case has none.
+func testBuildArguments(t *testing.T, testCases []buildArgumentsTestCase) {
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hdr := tc.handler
+			if hdr == nil {
+				hdr = &Handler{}
+			}
+			arguments, _, _, err := hdr.buildArguments(testdata.Medium, tc.params)
+			if err != nil {
+				if err, ok := err.(*imageserver.ParamError); ok && tc.expectedParamError == err.Param {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tc.expectedParamError != "" {
+				t.Fatal("no error")
+			}
+			argumentSlice := convertArgumentsToSlice(arguments)
+			if !reflect.DeepEqual(argumentSlice, tc.expectedArguments) && (len(argumentSlice) != 0 || len(tc.expectedArguments) != 0) {
+				t.Fatalf("unexpected arguments: got %q, want %q", argumentSlice, tc.expectedArguments)
+			}
+		})
+	}
+}
+
+func TestBuildArguments(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:   "Empty",
+			params: imageserver.Params{},
+		},
+	})
+}
+
+func TestBuildArgumentsResize(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Resize",
+			params:            imageserver.Params{"width": 100, "height": 200},
+			expectedArguments: []string{"-resize", "100x200"},
+		},
+		{
+			name:              "Thumbnail",
+			params:            imageserver.Params{"width": 100, "height": 200, "resize_mode": "thumbnail"},
+			expectedArguments: []string{"-thumbnail", "100x200"},
+		},
+		{
+			name:              "ThumbnailSuffixes",
+			params:            imageserver.Params{"width": 100, "height": 200, "resize_mode": "thumbnail", "fill": true, "ignore_ratio": true, "only_shrink_larger": true, "only_enlarge_smaller": true},
+			expectedArguments: []string{"-thumbnail", "100x200^!><"},
+		},
+		{
+			name:              "ThumbnailPercent",
+			params:            imageserver.Params{"resize_percent": 25.0, "resize_mode": "thumbnail"},
+			expectedArguments: []string{"-thumbnail", "25%"},
+		},
+		{
+			name:              "ThumbnailArea",
+			params:            imageserver.Params{"resize_area": 10000, "resize_mode": "thumbnail"},
+			expectedArguments: []string{"-thumbnail", "10000@"},
+		},
+		{
+			name:               "ThumbnailWithoutResize",
+			params:             imageserver.Params{"resize_mode": "thumbnail"},
+			expectedParamError: "resize_mode",
+		},
+		{
+			name:              "ResizeModeSample",
+			params:            imageserver.Params{"width": 100, "resize_mode": "sample"},
+			expectedArguments: []string{"-sample", "100x"},
+		},
+		{
+			name:              "ResizeModeScalePercent",
+			params:            imageserver.Params{"resize_percent": 50.0, "resize_mode": "scale"},
+			expectedArguments: []string{"-scale", "50%"},
+		},
+		{
+			name:              "ResizeModeResize",
+			params:            imageserver.Params{"width": 100, "resize_mode": "resize"},
+			expectedArguments: []string{"-resize", "100x"},
+		},
+		{
+			name:   "ResizeModeResizeWithoutResize",
+			params: imageserver.Params{"resize_mode": "resize"},
+		},
+		{
+			name:               "ResizeModeWithoutResize",
+			params:             imageserver.Params{"resize_mode": "sample"},
+			expectedParamError: "resize_mode",
+		},
+		{
+			name:               "ResizeModeUnknown",
+			params:             imageserver.Params{"width": 100, "resize_mode": "Sample"},
+			expectedParamError: "resize_mode",
+		},
+		{
+			name:               "ResizeModeInvalid",
+			params:             imageserver.Params{"width": 100, "resize_mode": 1},
+			expectedParamError: "resize_mode",
+		},
+		{
+			name:              "ResizePercent",
+			params:            imageserver.Params{"resize_percent": 50.0},
+			expectedArguments: []string{"-resize", "50%"},
+		},
+		{
+			name:              "ResizePercentFloat",
+			params:            imageserver.Params{"resize_percent": 12.5},
+			expectedArguments: []string{"-resize", "12.5%"},
+		},
+		{
+			name:               "ResizePercentWithWidth",
+			params:             imageserver.Params{"resize_percent": 50.0, "width": 100},
+			expectedParamError: "resize_percent",
+		},
+		{
+			name:               "ResizePercentZero",
+			params:             imageserver.Params{"resize_percent": 0.0},
+			expectedParamError: "resize_percent",
+		},
+		{
+			name:               "ResizePercentTooLarge",
+			params:             imageserver.Params{"resize_percent": 1000.5},
+			expectedParamError: "resize_percent",
+		},
+		{
+			name:              "ResizeArea",
+			params:            imageserver.Params{"resize_area": 250000},
+			expectedArguments: []string{"-resize", "250000@"},
+		},
+		{
+			name:               "ResizeAreaWithHeight",
+			params:             imageserver.Params{"resize_area": 250000, "height": 100},
+			expectedParamError: "resize_area",
+		},
+		{
+			name:               "ResizeAreaWithPercent",
+			params:             imageserver.Params{"resize_area": 250000, "resize_percent": 50.0},
+			expectedParamError: "resize_area",
+		},
+		{
+			name:               "ResizeAreaZero",
+			params:             imageserver.Params{"resize_area": 0},
+			expectedParamError: "resize_area",
+		},
+		{
+			name:               "ResizeAreaInvalid",
+			params:             imageserver.Params{"resize_area": "250000"},
+			expectedParamError: "resize_area",
+		},
+		{
+			name:               "ResizePercentInvalid",
+			params:             imageserver.Params{"resize_percent": 50},
+			expectedParamError: "resize_percent",
+		},
+	})
+}
+
+func TestBuildArgumentsFilter(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Filter",
+			params:            imageserver.Params{"width": 100, "filter": "lanczos"},
+			expectedArguments: []string{"-filter", "Lanczos", "-resize", "100x"},
+		},
+		{
+			name:              "FilterCaseInsensitive",
+			params:            imageserver.Params{"resize_percent": 50.0, "filter": "CatRom", "resize_mode": "thumbnail"},
+			expectedArguments: []string{"-filter", "Catrom", "-thumbnail", "50%"},
+		},
+		{
+			name:              "FilterOrder",
+			params:            imageserver.Params{"width": 100, "filter": "box", "gaussian_blur": "0x1", "sharpen": true},
+			expectedArguments: []string{"-gaussian", "0x1", "-filter", "Box", "-resize", "100x", "-sharpen", "0x1"},
+		},
+		{
+			name:              "FilterDefault",
+			handler:           &Handler{DefaultFilter: "lanczos"},
+			params:            imageserver.Params{"width": 100},
+			expectedArguments: []string{"-filter", "Lanczos", "-resize", "100x"},
+		},
+		{
+			name:              "FilterDefaultOverride",
+			handler:           &Handler{DefaultFilter: "lanczos"},
+			params:            imageserver.Params{"width": 100, "filter": "mitchell"},
+			expectedArguments: []string{"-filter", "Mitchell", "-resize", "100x"},
+		},
+		{
+			name:              "FilterDefaultWithoutResize",
+			handler:           &Handler{DefaultFilter: "lanczos"},
+			params:            imageserver.Params{"negate": true},
+			expectedArguments: []string{"-negate"},
+		},
+		{
+			name:               "FilterWithoutResize",
+			params:             imageserver.Params{"filter": "lanczos"},
+			expectedParamError: "filter",
+		},
+		{
+			name:               "FilterUnknown",
+			params:             imageserver.Params{"width": 100, "filter": "foo"},
+			expectedParamError: "filter",
+		},
+		{
+			name:               "FilterInvalid",
+			params:             imageserver.Params{"width": 100, "filter": 1},
+			expectedParamError: "filter",
+		},
+	})
+}
+
+func TestBuildArgumentsDPR(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "DPR",
+			params:            imageserver.Params{"width": 200, "dpr": 2.0},
+			expectedArguments: []string{"-resize", "400x"},
+		},
+		{
+			name:              "DPRRound",
+			params:            imageserver.Params{"width": 101, "height": 33, "dpr": 1.5},
+			expectedArguments: []string{"-resize", "152x50"},
+		},
+		{
+			name:              "DPRExtent",
+			params:            imageserver.Params{"width": 100, "height": 50, "dpr": 3.0, "extent": true},
+			expectedArguments: []string{"-resize", "300x150", "-gravity", "Center", "-extent", "300x150"},
+		},
+		{
+			name:              "DPRMax",
+			handler:           &Handler{MaxDPR: 2},
+			params:            imageserver.Params{"width": 200, "dpr": 3.0},
+			expectedArguments: []string{"-resize", "400x"},
+		},
+		{
+			name:              "DPRBelowMax",
+			handler:           &Handler{MaxDPR: 2},
+			params:            imageserver.Params{"height": 200, "dpr": 0.5},
+			expectedArguments: []string{"-resize", "x100"},
+		},
+		{
+			name:               "DPRTooSmall",
+			params:             imageserver.Params{"width": 200, "dpr": 0.25},
+			expectedParamError: "dpr",
+		},
+		{
+			name:               "DPRTooLarge",
+			params:             imageserver.Params{"width": 200, "dpr": 4.5},
+			expectedParamError: "dpr",
+		},
+		{
+			name:               "DPRWithoutDimensions",
+			params:             imageserver.Params{"dpr": 2.0},
+			expectedParamError: "dpr",
+		},
+		{
+			name:               "DPRInvalid",
+			params:             imageserver.Params{"width": 200, "dpr": 2},
+			expectedParamError: "dpr",
+		},
+	})
+}
+
+func TestBuildArgumentsMinMaxSize(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "MinWidth",
+			handler:           &Handler{MinWidth: 50, MinHeight: 50},
+			params:            imageserver.Params{"width": 50},
+			expectedArguments: []string{"-resize", "50x"},
+		},
+		{
+			name:               "MinWidthExceeded",
+			handler:            &Handler{MinWidth: 50},
+			params:             imageserver.Params{"width": 49},
+			expectedParamError: "width",
+		},
+		{
+			name:              "MinWidthDPR",
+			handler:           &Handler{MinWidth: 50},
+			params:            imageserver.Params{"width": 25, "dpr": 2.0},
+			expectedArguments: []string{"-resize", "50x"},
+		},
+		{
+			name:               "MinHeightExceeded",
+			handler:            &Handler{MinHeight: 50},
+			params:             imageserver.Params{"width": 100, "height": 10},
+			expectedParamError: "height",
+		},
+		{
+			name:    "MinWidthNoResize",
+			handler: &Handler{MinWidth: 50},
+			params:  imageserver.Params{"width": 0, "height": 0},
+		},
+		{
+			name:               "MinWidthCropExceeded",
+			handler:            &Handler{MinWidth: 50},
+			params:             imageserver.Params{"crop": "10,100,0,0"},
+			expectedParamError: "crop",
+		},
+		{
+			name:              "MaxWidth",
+			handler:           &Handler{MaxWidth: 1000},
+			params:            imageserver.Params{"width": 1000},
+			expectedArguments: []string{"-resize", "1000x"},
+		},
+		{
+			name:               "MaxWidthExceeded",
+			handler:            &Handler{MaxWidth: 1000},
+			params:             imageserver.Params{"width": 2000},
+			expectedParamError: "width",
+		},
+		{
+			name:               "MaxWidthExceededDPR",
+			handler:            &Handler{MaxWidth: 1000},
+			params:             imageserver.Params{"width": 600, "dpr": 2.0},
+			expectedParamError: "width",
+		},
+		{
+			name:               "MaxWidthExceededHeight",
+			handler:            &Handler{MaxWidth: 100},
+			params:             imageserver.Params{"height": 100},
+			expectedParamError: "height",
+		},
+		{
+			name:               "MaxHeightExceeded",
+			handler:            &Handler{MaxHeight: 1000},
+			params:             imageserver.Params{"height": 1001},
+			expectedParamError: "height",
+		},
+		{
+			name:              "MaxWidthFill",
+			handler:           &Handler{MaxWidth: 120},
+			params:            imageserver.Params{"width": 100, "height": 100},
+			expectedArguments: []string{"-resize", "100x100"},
+		},
+		{
+			name:               "MaxWidthExceededFill",
+			handler:            &Handler{MaxWidth: 120},
+			params:             imageserver.Params{"width": 100, "height": 100, "fill": true},
+			expectedParamError: "width",
+		},
+		{
+			name:              "MaxPixels",
+			handler:           &Handler{MaxPixels: 900000},
+			params:            imageserver.Params{"width": 1000, "height": 1000},
+			expectedArguments: []string{"-resize", "1000x1000"},
+		},
+		{
+			name:               "MaxPixelsExceededIgnoreRatio",
+			handler:            &Handler{MaxPixels: 900000},
+			params:             imageserver.Params{"width": 1000, "height": 1000, "ignore_ratio": true},
+			expectedParamError: "width",
+		},
+		{
+			name:              "MaxPixelsOnlyShrinkLarger",
+			handler:           &Handler{MaxPixels: 1000000},
+			params:            imageserver.Params{"width": 2000, "only_shrink_larger": true},
+			expectedArguments: []string{"-resize", "2000x>"},
+		},
+		{
+			name:               "MaxPixelsExceeded",
+			handler:            &Handler{MaxPixels: 1000000},
+			params:             imageserver.Params{"width": 2000},
+			expectedParamError: "width",
+		},
+		{
+			name:               "MaxPixelsExceededExtent",
+			handler:            &Handler{MaxPixels: 1000000},
+			params:             imageserver.Params{"width": 2000, "height": 2000, "only_shrink_larger": true, "extent": true},
+			expectedParamError: "extent",
+		},
+		{
+			name:               "MaxPixelsExceededPad",
+			handler:            &Handler{MaxPixels: 1000000},
+			params:             imageserver.Params{"width": 2000, "height": 2000, "only_shrink_larger": true, "pad": true},
+			expectedParamError: "pad",
+		},
+		{
+			name:               "MaxWidthExceededResizePercent",
+			handler:            &Handler{MaxWidth: 2000},
+			params:             imageserver.Params{"resize_percent": 200.0},
+			expectedParamError: "resize_percent",
+		},
+		{
+			name:               "MaxPixelsExceededResizeArea",
+			handler:            &Handler{MaxPixels: 1000000},
+			params:             imageserver.Params{"resize_area": 2000000},
+			expectedParamError: "resize_area",
+		},
+		{
+			name:              "MaxWidthCrop",
+			handler:           &Handler{MaxWidth: 1000},
+			params:            imageserver.Params{"crop": "1000,100,0,0", "width": 2000, "only_shrink_larger": true},
+			expectedArguments: []string{"-crop", "1000x100+0+0", "+repage", "-resize", "2000x>"},
+		},
+		{
+			name:               "MaxWidthExceededCrop",
+			handler:            &Handler{MaxWidth: 1000},
+			params:             imageserver.Params{"crop": "2000,100,0,0"},
+			expectedParamError: "crop",
+		},
+	})
+}
+
+func TestBuildArgumentsCrop(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Crop",
+			params:            imageserver.Params{"crop": "200,100,10,20"},
+			expectedArguments: []string{"-crop", "200x100+10+20", "+repage"},
+		},
+		{
+			name:              "CropPercent",
+			params:            imageserver.Params{"crop": "50%,50%,25%,25%"},
+			expectedArguments: []string{"-crop", "512x410+256+205", "+repage"},
+		},
+		{
+			name:              "CropPercentDecimal",
+			params:            imageserver.Params{"crop": "12.5%,100%,0%,0%"},
+			expectedArguments: []string{"-crop", "128x819+0+0", "+repage"},
+		},
+		{
+			name:              "CropMixed",
+			params:            imageserver.Params{"crop": "300,50%,10%,0"},
+			expectedArguments: []string{"-crop", "300x410+102+0", "+repage"},
+		},
+		{
+			name:              "CropPercentTranspose",
+			params:            imageserver.Params{"crop": "50%,50%,0,0", "transpose": true},
+			expectedArguments: []string{"-flip", "-rotate", "90", "-crop", "410x512+0+0", "+repage"},
+		},
+		{
+			name:              "CropResize",
+			params:            imageserver.Params{"crop": "200,100,10,20", "width": 50},
+			expectedArguments: []string{"-crop", "200x100+10+20", "+repage", "-resize", "50x"},
+		},
+		{
+			name:              "CropDPR",
+			params:            imageserver.Params{"crop": "200,100,10,20", "width": 50, "dpr": 2.0},
+			expectedArguments: []string{"-crop", "400x200+20+40", "+repage", "-resize", "100x"},
+		},
+		{
+			name:              "CropDPRPercent",
+			params:            imageserver.Params{"crop": "50%,100,25%,5", "width": 50, "dpr": 1.5},
+			expectedArguments: []string{"-crop", "512x150+256+8", "+repage", "-resize", "75x"},
+		},
+		{
+			name:               "CropNotNumeric",
+			params:             imageserver.Params{"crop": "200,abc,10,20"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropPercentNotNumeric",
+			params:             imageserver.Params{"crop": "50%,50%,a%,25%"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropPercentOutOfRange",
+			params:             imageserver.Params{"crop": "150%,50%,0,0"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropEmptyComponent",
+			params:             imageserver.Params{"crop": "200,,10,20"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropNegative",
+			params:             imageserver.Params{"crop": "200,100,-10,20"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropComponentsCount",
+			params:             imageserver.Params{"crop": "200,100"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropZeroSize",
+			params:             imageserver.Params{"crop": "0,100,0,0"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropInvalidType",
+			params:             imageserver.Params{"crop": 1},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropWithCropRatio",
+			params:             imageserver.Params{"crop": "200,100,10,20", "crop_ratio": "1:1"},
+			expectedParamError: "crop",
+		},
+		{
+			name:              "CropRatio",
+			params:            imageserver.Params{"crop_ratio": "16:9"},
+			expectedArguments: []string{"-gravity", "Center", "-crop", "1024x576+0+0", "+repage"},
+		},
+		{
+			name:              "CropRatioSquare",
+			params:            imageserver.Params{"crop_ratio": "1:1", "gravity": "w"},
+			expectedArguments: []string{"-gravity", "West", "-crop", "819x819+0+0", "+repage"},
+		},
+		{
+			name:              "CropRatioResize",
+			params:            imageserver.Params{"crop_ratio": "1:1", "width": 100, "height": 100},
+			expectedArguments: []string{"-gravity", "Center", "-crop", "819x819+0+0", "+repage", "-resize", "100x100"},
+		},
+		{
+			name:              "CropRatioTranspose",
+			params:            imageserver.Params{"crop_ratio": "16:9", "transpose": true},
+			expectedArguments: []string{"-flip", "-rotate", "90", "-gravity", "Center", "-crop", "819x461+0+0", "+repage"},
+		},
+		{
+			name:              "CropFocus",
+			params:            imageserver.Params{"crop_ratio": "1:1", "crop_focus_x": 25.0, "crop_focus_y": 50.0},
+			expectedArguments: []string{"-crop", "819x819+0+0", "+repage"},
+		},
+		{
+			name:              "CropFocusRight",
+			params:            imageserver.Params{"crop_ratio": "1:2", "crop_focus_x": 75.0},
+			expectedArguments: []string{"-crop", "410x819+563+0", "+repage"},
+		},
+		{
+			name:              "CropFocusClampedRight",
+			params:            imageserver.Params{"crop_ratio": "1:1", "crop_focus_x": 100.0},
+			expectedArguments: []string{"-crop", "819x819+205+0", "+repage"},
+		},
+		{
+			name:              "CropFocusTop",
+			params:            imageserver.Params{"crop_ratio": "16:9", "crop_focus_y": 20.0},
+			expectedArguments: []string{"-crop", "1024x576+0+0", "+repage"},
+		},
+		{
+			name:              "CropFocusBottom",
+			params:            imageserver.Params{"crop_ratio": "16:9", "crop_focus_y": 60.0},
+			expectedArguments: []string{"-crop", "1024x576+0+203", "+repage"},
+		},
+		{
+			name:               "CropFocusOutOfRange",
+			params:             imageserver.Params{"crop_ratio": "1:1", "crop_focus_x": 101.0},
+			expectedParamError: "crop_focus_x",
+		},
+		{
+			name:               "CropFocusNegative",
+			params:             imageserver.Params{"crop_ratio": "1:1", "crop_focus_y": -1.0},
+			expectedParamError: "crop_focus_y",
+		},
+		{
+			name:               "CropFocusInvalidType",
+			params:             imageserver.Params{"crop_ratio": "1:1", "crop_focus_x": 50},
+			expectedParamError: "crop_focus_x",
+		},
+		{
+			name:               "CropFocusWithoutCrop",
+			params:             imageserver.Params{"crop_focus_x": 50.0},
+			expectedParamError: "crop_focus_x",
+		},
+		{
+			name:               "CropRatioInvalidFormat",
+			params:             imageserver.Params{"crop_ratio": "16/9"},
+			expectedParamError: "crop_ratio",
+		},
+		{
+			name:               "CropRatioZero",
+			params:             imageserver.Params{"crop_ratio": "0:1"},
+			expectedParamError: "crop_ratio",
+		},
+		{
+			name:               "CropRatioNegative",
+			params:             imageserver.Params{"crop_ratio": "1:-1"},
+			expectedParamError: "crop_ratio",
+		},
+		{
+			name:               "CropRatioNotInteger",
+			params:             imageserver.Params{"crop_ratio": "1.5:1"},
+			expectedParamError: "crop_ratio",
+		},
+		{
+			name:               "CropRatioInvalidType",
+			params:             imageserver.Params{"crop_ratio": 1},
+			expectedParamError: "crop_ratio",
+		},
+		{
+			name:               "CropRatioGravityInvalid",
+			params:             imageserver.Params{"crop_ratio": "1:1", "gravity": "foo"},
+			expectedParamError: "gravity",
+		},
+		{
+			name:               "CropZeroHeight",
+			params:             imageserver.Params{"crop": "100,0,0,0"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropZeroPercent",
+			params:             imageserver.Params{"crop": "0%,50%,0,0"},
+			expectedParamError: "crop",
+		},
+		{
+			name:               "CropTwoComponents",
+			params:             imageserver.Params{"crop": "0,0"},
+			expectedParamError: "crop",
+		},
+	})
+}
+
+func TestBuildArgumentsTrim(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Trim",
+			params:            imageserver.Params{"trim": true, "width": 100},
+			expectedArguments: []string{"-trim", "+repage", "-resize", "100x"},
+		},
+		{
+			name:              "TrimFuzz",
+			params:            imageserver.Params{"trim": true, "trim_fuzz": 10.5, "width": 100},
+			expectedArguments: []string{"-fuzz", "10.5%", "-trim", "+repage", "-fuzz", "0%", "-resize", "100x"},
+		},
+		{
+			name:              "TrimFuzzAutoOrient",
+			params:            imageserver.Params{"trim": true, "trim_fuzz": 5.0, "auto_orient": true},
+			expectedArguments: []string{"-auto-orient", "-fuzz", "5%", "-trim", "+repage", "-fuzz", "0%"},
+		},
+		{
+			name:              "TrimFuzzOrder",
+			params:            imageserver.Params{"trim": true, "trim_fuzz": 3.0, "auto_orient": true, "transpose": true, "crop": "10,10,0,0", "width": 5, "density": "72"},
+			expectedArguments: []string{"-density", "72", "-auto-orient", "-fuzz", "3%", "-trim", "+repage", "-fuzz", "0%", "-flip", "-rotate", "90", "-crop", "10x10+0+0", "+repage", "-resize", "5x"},
+		},
+		{
+			name:   "TrimFalse",
+			params: imageserver.Params{"trim": false},
+		},
+		{
+			name:               "TrimInvalid",
+			params:             imageserver.Params{"trim": "true"},
+			expectedParamError: "trim",
+		},
+		{
+			name:               "TrimFuzzWithoutTrim",
+			params:             imageserver.Params{"trim_fuzz": 10.0},
+			expectedParamError: "trim_fuzz",
+		},
+		{
+			name:               "TrimFuzzTrimFalse",
+			params:             imageserver.Params{"trim": false, "trim_fuzz": 10.0},
+			expectedParamError: "trim_fuzz",
+		},
+		{
+			name:               "TrimFuzzOutOfRange",
+			params:             imageserver.Params{"trim": true, "trim_fuzz": 101.0},
+			expectedParamError: "trim_fuzz",
+		},
+		{
+			name:               "TrimFuzzInvalid",
+			params:             imageserver.Params{"trim": true, "trim_fuzz": 10},
+			expectedParamError: "trim_fuzz",
+		},
+	})
+}
+
+func TestBuildArgumentsRotate(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Rotate",
+			params:            imageserver.Params{"width": 100, "rotate": 90.0},
+			expectedArguments: []string{"-resize", "100x", "-rotate", "90"},
+		},
+		{
+			name:              "RotateNegative",
+			params:            imageserver.Params{"rotate": -90.0},
+			expectedArguments: []string{"-rotate", "270"},
+		},
+		{
+			name:              "RotateOver360",
+			params:            imageserver.Params{"rotate": 450.0},
+			expectedArguments: []string{"-rotate", "90"},
+		},
+		{
+			name:              "RotateFractional",
+			params:            imageserver.Params{"rotate": 12.5},
+			expectedArguments: []string{"-rotate", "12.5"},
+		},
+		{
+			name:   "Rotate360",
+			params: imageserver.Params{"rotate": -720.0},
+		},
+		{
+			name:              "RotateBackground",
+			params:            imageserver.Params{"rotate": 30.0, "background": "ff0000"},
+			expectedArguments: []string{"-background", "#ff0000", "-rotate", "30"},
+		},
+		{
+			name:              "RotateBackgroundShear",
+			params:            imageserver.Params{"rotate": 30.0, "background": "ff0000", "shear": "10"},
+			expectedArguments: []string{"-background", "#ff0000", "-rotate", "30", "-shear", "10"},
+		},
+		{
+			name:   "RotateZero",
+			params: imageserver.Params{"rotate": 0.0},
+		},
+		{
+			name:              "RotateExpand",
+			params:            imageserver.Params{"rotate": 30.0, "rotate_expand": true},
+			expectedArguments: []string{"-rotate", "30"},
+		},
+		{
+			name:              "RotateNoExpand",
+			params:            imageserver.Params{"rotate": 30.0, "rotate_expand": false},
+			expectedArguments: []string{"-rotate", "30", "-gravity", "Center", "-crop", "1024x819+0+0", "+repage"},
+		},
+		{
+			name:              "RotateNoExpandResize",
+			params:            imageserver.Params{"width": 100, "rotate": 30.0, "rotate_expand": false, "background": "000"},
+			expectedArguments: []string{"-resize", "100x", "-background", "#000", "-rotate", "30", "-gravity", "Center", "-crop", "100x80+0+0", "+repage"},
+		},
+		{
+			name:              "RotateNoExpandCrop",
+			params:            imageserver.Params{"crop": "200,100,0,0", "rotate": 45.0, "rotate_expand": false},
+			expectedArguments: []string{"-crop", "200x100+0+0", "+repage", "-rotate", "45", "-gravity", "Center", "-crop", "200x100+0+0", "+repage"},
+		},
+		{
+			name:   "RotateNoExpandZero",
+			params: imageserver.Params{"rotate": 360.0, "rotate_expand": false},
+		},
+		{
+			name:               "RotateExpandWithoutRotate",
+			params:             imageserver.Params{"rotate_expand": false},
+			expectedParamError: "rotate_expand",
+		},
+		{
+			name:               "RotateExpandInvalid",
+			params:             imageserver.Params{"rotate": 30.0, "rotate_expand": "false"},
+			expectedParamError: "rotate_expand",
+		},
+		{
+			name:               "RotateInvalid",
+			params:             imageserver.Params{"rotate": "90"},
+			expectedParamError: "rotate",
+		},
+	})
+}
+
+func TestBuildArgumentsAutoOrient(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "AutoOrient",
+			params:            imageserver.Params{"width": 100, "auto_orient": true},
+			expectedArguments: []string{"-auto-orient", "-resize", "100x"},
+		},
+		{
+			name:              "AutoOrientFalse",
+			params:            imageserver.Params{"width": 100, "auto_orient": false},
+			expectedArguments: []string{"-resize", "100x"},
+		},
+		{
+			name:              "AutoOrientDefault",
+			handler:           &Handler{AutoOrientDefault: true},
+			params:            imageserver.Params{"width": 100},
+			expectedArguments: []string{"-auto-orient", "-resize", "100x"},
+		},
+		{
+			name:              "AutoOrientDefaultNoParams",
+			handler:           &Handler{AutoOrientDefault: true},
+			params:            imageserver.Params{},
+			expectedArguments: []string{"-auto-orient"},
+		},
+		{
+			name:              "AutoOrientDefaultOverride",
+			handler:           &Handler{AutoOrientDefault: true},
+			params:            imageserver.Params{"width": 100, "auto_orient": false},
+			expectedArguments: []string{"-resize", "100x"},
+		},
+		{
+			name:              "AutoOrientFirst",
+			params:            imageserver.Params{"width": 100, "height": 100, "extent": true, "gaussian_blur": "2x1.5", "auto_orient": true},
+			expectedArguments: []string{"-auto-orient", "-gaussian", "2x1.5", "-resize", "100x100", "-gravity", "Center", "-extent", "100x100"},
+		},
+		{
+			name:               "AutoOrientInvalid",
+			params:             imageserver.Params{"auto_orient": "foo"},
+			expectedParamError: "auto_orient",
+		},
+	})
+}
+
+func TestBuildArgumentsTransposeTransverse(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Transpose",
+			params:            imageserver.Params{"width": 100, "transpose": true},
+			expectedArguments: []string{"-flip", "-rotate", "90", "-resize", "100x"},
+		},
+		{
+			name:              "Transverse",
+			params:            imageserver.Params{"transverse": true, "auto_orient": true},
+			expectedArguments: []string{"-auto-orient", "-flop", "-rotate", "90"},
+		},
+		{
+			name:              "TransposeTransverse",
+			params:            imageserver.Params{"transpose": true, "transverse": true},
+			expectedArguments: []string{"-flip", "-rotate", "90", "-flop", "-rotate", "90"},
+		},
+		{
+			name:   "TransposeFalse",
+			params: imageserver.Params{"transpose": false, "transverse": false},
+		},
+		{
+			name:              "TransposeImageMagick",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"width": 100, "transpose": true},
+			expectedArguments: []string{"-transpose", "-resize", "100x"},
+		},
+		{
+			name:              "TransverseImageMagick",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"transverse": true},
+			expectedArguments: []string{"-transverse"},
+		},
+		{
+			name:              "TransposeTransverseImageMagick",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"transpose": true, "transverse": true},
+			expectedArguments: []string{"-transpose", "-transverse"},
+		},
+		{
+			name:              "TransposeRotate",
+			params:            imageserver.Params{"transpose": true, "rotate": 90.0},
+			expectedArguments: []string{"-flip", "-rotate", "90", "-rotate", "90"},
+		},
+		{
+			name:              "TransverseRotateImageMagick",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"transverse": true, "rotate": -90.0, "width": 100},
+			expectedArguments: []string{"-transverse", "-resize", "100x", "-rotate", "270"},
+		},
+		{
+			name:               "TransposeInvalid",
+			params:             imageserver.Params{"transpose": "true"},
+			expectedParamError: "transpose",
+		},
+		{
+			name:               "TransverseInvalid",
+			params:             imageserver.Params{"transverse": 1},
+			expectedParamError: "transverse",
+		},
+	})
+}
+
+func TestBuildArgumentsDensity(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Density",
+			params:            imageserver.Params{"density": "300"},
+			expectedArguments: []string{"-density", "300"},
+		},
+		{
+			name:              "DensityXY",
+			params:            imageserver.Params{"density": "300x150.5"},
+			expectedArguments: []string{"-density", "300x150.5"},
+		},
+		{
+			name:              "DensityFront",
+			params:            imageserver.Params{"width": 100, "auto_orient": true, "format": "png", "density": "144"},
+			expectedArguments: []string{"-density", "144", "-auto-orient", "-resize", "100x", "-format", "png"},
+		},
+		{
+			name:               "DensityZero",
+			params:             imageserver.Params{"density": "0"},
+			expectedParamError: "density",
+		},
+		{
+			name:               "DensityNegativeY",
+			params:             imageserver.Params{"density": "300x-1"},
+			expectedParamError: "density",
+		},
+		{
+			name:               "DensityInvalidFormat",
+			params:             imageserver.Params{"density": "1x2x3"},
+			expectedParamError: "density",
+		},
+		{
+			name:               "DensityInvalid",
+			params:             imageserver.Params{"density": "foo"},
+			expectedParamError: "density",
+		},
+		{
+			name:               "DensityInvalidType",
+			params:             imageserver.Params{"density": 300},
+			expectedParamError: "density",
+		},
+	})
+}
+
+func TestBuildArgumentsBlur(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Blur",
+			params:            imageserver.Params{"width": 100, "blur": "0x3"},
+			expectedArguments: []string{"-resize", "100x", "-blur", "0x3"},
+		},
+		{
+			name:              "BlurSigma",
+			params:            imageserver.Params{"blur": "1.5"},
+			expectedArguments: []string{"-blur", "0x1.5"},
+		},
+		{
+			name:              "BlurComma",
+			params:            imageserver.Params{"blur": "1,2.5"},
+			expectedArguments: []string{"-blur", "1x2.5"},
+		},
+		{
+			name:              "BlurMaxSigma",
+			handler:           &Handler{MaxBlurSigma: 5},
+			params:            imageserver.Params{"blur": "5"},
+			expectedArguments: []string{"-blur", "0x5"},
+		},
+		{
+			name:               "BlurMaxSigmaExceeded",
+			handler:            &Handler{MaxBlurSigma: 5},
+			params:             imageserver.Params{"blur": "0x5.5"},
+			expectedParamError: "blur",
+		},
+		{
+			name:               "BlurInvalid",
+			params:             imageserver.Params{"blur": "foo"},
+			expectedParamError: "blur",
+		},
+		{
+			name:               "BlurInvalidFormat",
+			params:             imageserver.Params{"blur": "1x2x3"},
+			expectedParamError: "blur",
+		},
+		{
+			name:               "BlurNegativeRadius",
+			params:             imageserver.Params{"blur": "-1x3"},
+			expectedParamError: "blur",
+		},
+		{
+			name:               "BlurNegativeSigma",
+			params:             imageserver.Params{"blur": "1x-3"},
+			expectedParamError: "blur",
+		},
+		{
+			name:               "BlurNegativeSigmaComma",
+			params:             imageserver.Params{"blur": "1,-3"},
+			expectedParamError: "blur",
+		},
+		{
+			name:              "GaussianBlur",
+			params:            imageserver.Params{"width": 100, "gaussian_blur": "2x1.5"},
+			expectedArguments: []string{"-gaussian", "2x1.5", "-resize", "100x"},
+		},
+		{
+			name:              "GaussianBlurImageMagick",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"width": 100, "gaussian_blur": "2x1.5"},
+			expectedArguments: []string{"-gaussian-blur", "2x1.5", "-resize", "100x"},
+		},
+		{
+			name:               "GaussianBlurSigmaMissing",
+			params:             imageserver.Params{"gaussian_blur": "2"},
+			expectedParamError: "gaussian_blur",
+		},
+		{
+			name:               "GaussianBlurSigmaZero",
+			params:             imageserver.Params{"gaussian_blur": "2x0"},
+			expectedParamError: "gaussian_blur",
+		},
+		{
+			name:               "GaussianBlurInvalid",
+			params:             imageserver.Params{"gaussian_blur": "2xfoo"},
+			expectedParamError: "gaussian_blur",
+		},
+	})
+}
+
+func TestBuildArgumentsDespeckle(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Despeckle",
+			handler:           &Handler{AllowExpensiveFilters: true},
+			params:            imageserver.Params{"width": 100, "despeckle": true},
+			expectedArguments: []string{"-despeckle", "-resize", "100x"},
+		},
+		{
+			name:              "DespeckleCount",
+			handler:           &Handler{AllowExpensiveFilters: true},
+			params:            imageserver.Params{"despeckle": 3},
+			expectedArguments: []string{"-despeckle", "-despeckle", "-despeckle"},
+		},
+		{
+			name:    "DespeckleFalse",
+			handler: &Handler{AllowExpensiveFilters: true},
+			params:  imageserver.Params{"despeckle": false},
+		},
+		{
+			name:               "DespeckleCountTooLarge",
+			handler:            &Handler{AllowExpensiveFilters: true},
+			params:             imageserver.Params{"despeckle": 6},
+			expectedParamError: "despeckle",
+		},
+		{
+			name:               "DespeckleInvalidType",
+			handler:            &Handler{AllowExpensiveFilters: true},
+			params:             imageserver.Params{"despeckle": "foo"},
+			expectedParamError: "despeckle",
+		},
+		{
+			name:               "DespeckleNotAllowed",
+			params:             imageserver.Params{"despeckle": true},
+			expectedParamError: "despeckle",
+		},
+	})
+}
+
+func TestBuildArgumentsMedian(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Median",
+			handler:           &Handler{AllowExpensiveFilters: true},
+			params:            imageserver.Params{"width": 100, "median": 3},
+			expectedArguments: []string{"-median", "3", "-resize", "100x"},
+		},
+		{
+			name:               "MedianEven",
+			handler:            &Handler{AllowExpensiveFilters: true},
+			params:             imageserver.Params{"median": 4},
+			expectedParamError: "median",
+		},
+		{
+			name:               "MedianOutOfRange",
+			handler:            &Handler{AllowExpensiveFilters: true},
+			params:             imageserver.Params{"median": 11},
+			expectedParamError: "median",
+		},
+		{
+			name:               "MedianNotAllowed",
+			params:             imageserver.Params{"median": 3},
+			expectedParamError: "median",
+		},
+	})
+}
+
+func TestBuildArgumentsSharpen(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "SharpenBool",
+			params:            imageserver.Params{"width": 100, "sharpen": true},
+			expectedArguments: []string{"-resize", "100x", "-sharpen", "0x1"},
+		},
+		{
+			name:   "SharpenBoolFalse",
+			params: imageserver.Params{"sharpen": false},
+		},
+		{
+			name:              "SharpenString",
+			params:            imageserver.Params{"width": 100, "sharpen": "1x0.5"},
+			expectedArguments: []string{"-resize", "100x", "-sharpen", "1x0.5"},
+		},
+		{
+			name:               "SharpenZero",
+			params:             imageserver.Params{"sharpen": "0x0"},
+			expectedParamError: "sharpen",
+		},
+		{
+			name:               "SharpenInvalidType",
+			params:             imageserver.Params{"sharpen": 1},
+			expectedParamError: "sharpen",
+		},
+		{
+			name:               "SharpenInvalid",
+			params:             imageserver.Params{"sharpen": "1xfoo"},
+			expectedParamError: "sharpen",
+		},
+		{
+			name:               "SharpenNegative",
+			params:             imageserver.Params{"sharpen": "-1x0.5"},
+			expectedParamError: "sharpen",
+		},
+		{
+			name:              "Unsharp",
+			params:            imageserver.Params{"width": 100, "unsharp": "1.5x1"},
+			expectedArguments: []string{"-resize", "100x", "-unsharp", "1.5x1"},
+		},
+		{
+			name:              "UnsharpAmount",
+			params:            imageserver.Params{"unsharp": "1.5x1+0.8"},
+			expectedArguments: []string{"-unsharp", "1.5x1+0.8"},
+		},
+		{
+			name:              "UnsharpAmountThreshold",
+			params:            imageserver.Params{"unsharp": "1.5x1+0.8+0.05"},
+			expectedArguments: []string{"-unsharp", "1.5x1+0.8+0.05"},
+		},
+		{
+			name:               "UnsharpSigmaOnly",
+			params:             imageserver.Params{"unsharp": "1"},
+			expectedParamError: "unsharp",
+		},
+		{
+			name:               "UnsharpNegativeRadius",
+			params:             imageserver.Params{"unsharp": "-1x1"},
+			expectedParamError: "unsharp",
+		},
+		{
+			name:               "UnsharpAmountTooLarge",
+			params:             imageserver.Params{"unsharp": "1.5x1+5.5"},
+			expectedParamError: "unsharp",
+		},
+		{
+			name:               "UnsharpAmountInvalid",
+			params:             imageserver.Params{"unsharp": "1.5x1+foo"},
+			expectedParamError: "unsharp",
+		},
+		{
+			name:               "UnsharpThresholdInvalid",
+			params:             imageserver.Params{"unsharp": "1.5x1+1+foo"},
+			expectedParamError: "unsharp",
+		},
+		{
+			name:               "UnsharpTooManyParts",
+			params:             imageserver.Params{"unsharp": "1.5x1+1+1+1"},
+			expectedParamError: "unsharp",
+		},
+	})
+}
+
+func TestBuildArgumentsColorspace(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Type",
+			params:            imageserver.Params{"type": "bilevel"},
+			expectedArguments: []string{"-type", "Bilevel"},
+		},
+		{
+			name:              "TypeTrueColor",
+			params:            imageserver.Params{"width": 100, "type": "TrueColor"},
+			expectedArguments: []string{"-resize", "100x", "-type", "TrueColor"},
+		},
+		{
+			name:              "TypeTrueColorMatte",
+			params:            imageserver.Params{"type": "truecolormatte", "format": "png"},
+			expectedArguments: []string{"-type", "TrueColorMatte", "-format", "png"},
+		},
+		{
+			name:              "TypeOrder",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"type": "grayscale", "colorspace": "gray", "posterize": 4, "colors": 16, "format": "png"},
+			expectedArguments: []string{"-type", "Grayscale", "-colorspace", "GRAY", "-posterize", "4", "-colors", "16", "-format", "png"},
+		},
+		{
+			name:               "TypeUnknown",
+			params:             imageserver.Params{"type": "cmyk"},
+			expectedParamError: "type",
+		},
+		{
+			name:               "TypeInvalid",
+			params:             imageserver.Params{"type": 1},
+			expectedParamError: "type",
+		},
+		{
+			name:              "Colorspace",
+			params:            imageserver.Params{"width": 100, "colorspace": "CMYK"},
+			expectedArguments: []string{"-resize", "100x", "-colorspace", "CMYK"},
+		},
+		{
+			name:              "ColorspaceCaseInsensitive",
+			params:            imageserver.Params{"colorspace": "ycbcr"},
+			expectedArguments: []string{"-colorspace", "YCbCr"},
+		},
+		{
+			name:               "ColorspaceNotAllowed",
+			params:             imageserver.Params{"colorspace": "HSL"},
+			expectedParamError: "colorspace",
+		},
+		{
+			name:               "ColorspaceInvalidType",
+			params:             imageserver.Params{"colorspace": 1},
+			expectedParamError: "colorspace",
+		},
+	})
+}
+
+func TestBuildArgumentsNormalizeEqualize(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Normalize",
+			params:            imageserver.Params{"normalize": true},
+			expectedArguments: []string{"-normalize"},
+		},
+		{
+			name:              "Equalize",
+			params:            imageserver.Params{"equalize": true},
+			expectedArguments: []string{"-equalize"},
+		},
+		{
+			name:               "NormalizeEqualize",
+			params:             imageserver.Params{"equalize": true, "normalize": true},
+			expectedParamError: "equalize",
+		},
+		{
+			name:              "NormalizeEqualizeOneFalse",
+			params:            imageserver.Params{"equalize": true, "normalize": false},
+			expectedArguments: []string{"-equalize"},
+		},
+		{
+			name:   "NormalizeEqualizeFalse",
+			params: imageserver.Params{"equalize": false, "normalize": false},
+		},
+		{
+			name:               "NormalizeInvalid",
+			params:             imageserver.Params{"normalize": "foo"},
+			expectedParamError: "normalize",
+		},
+		{
+			name:               "EqualizeInvalid",
+			params:             imageserver.Params{"equalize": "foo"},
+			expectedParamError: "equalize",
+		},
+	})
+}
+
+func TestBuildArgumentsLevel(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Level",
+			params:            imageserver.Params{"width": 100, "level": "5%,1.0,95%"},
+			expectedArguments: []string{"-resize", "100x", "-level", "5%,1,95%"},
+		},
+		{
+			name:              "LevelBlackGamma",
+			params:            imageserver.Params{"level": "10,1.2"},
+			expectedArguments: []string{"-level", "10,1.2"},
+		},
+		{
+			name:              "LevelMixedUnits",
+			params:            imageserver.Params{"level": "10,1,50%"},
+			expectedArguments: []string{"-level", "10,1,50%"},
+		},
+		{
+			name:               "LevelBlackEqualWhite",
+			params:             imageserver.Params{"level": "50%,1,50%"},
+			expectedParamError: "level",
+		},
+		{
+			name:               "LevelBlackGreaterWhite",
+			params:             imageserver.Params{"level": "200,1,50%"},
+			expectedParamError: "level",
+		},
+		{
+			name:               "LevelPointOutOfRange",
+			params:             imageserver.Params{"level": "101%"},
+			expectedParamError: "level",
+		},
+		{
+			name:               "LevelInvalid",
+			params:             imageserver.Params{"level": "5%,foo"},
+			expectedParamError: "level",
+		},
+	})
+}
+
+func TestBuildArgumentsContrastStretch(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "ContrastStretch",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"width": 100, "contrast_stretch": "2"},
+			expectedArguments: []string{"-resize", "100x", "-contrast-stretch", "2%x2%"},
+		},
+		{
+			name:              "ContrastStretchLowHigh",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"contrast_stretch": "2,1.5"},
+			expectedArguments: []string{"-contrast-stretch", "2%x1.5%"},
+		},
+		{
+			name:              "ContrastStretchPercentages",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"contrast_stretch": "2%x1%"},
+			expectedArguments: []string{"-contrast-stretch", "2%x1%"},
+		},
+		{
+			name:              "ContrastStretchPercent",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"contrast_stretch": "0.5%"},
+			expectedArguments: []string{"-contrast-stretch", "0.5%x0.5%"},
+		},
+		{
+			name:               "ContrastStretchPercentagesMissingHigh",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "2%x"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchPercentagesSingle",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "x1%"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchPercentagesTooMany",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "1%x2%x3%"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchPercentagesOutOfRange",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "2%x101%"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchMixedSeparators",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "1,2%x3%"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchOutOfRange",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "2,101"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchSumTooLarge",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "50"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchInvalid",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "foo"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchTooManyValues",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"contrast_stretch": "1,2,3"},
+			expectedParamError: "contrast_stretch",
+		},
+		{
+			name:               "ContrastStretchGraphicsMagick",
+			params:             imageserver.Params{"contrast_stretch": "2"},
+			expectedParamError: "contrast_stretch",
+		},
+	})
+}
+
+func TestBuildArgumentsSigmoidalContrast(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "SigmoidalContrast",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"sigmoidal_contrast": "3,50%"},
+			expectedArguments: []string{"-sigmoidal-contrast", "3x50%"},
+		},
+		{
+			name:              "SigmoidalContrastDefaultMidPoint",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"width": 100, "sigmoidal_contrast": "4.5"},
+			expectedArguments: []string{"-resize", "100x", "-sigmoidal-contrast", "4.5x50%"},
+		},
+		{
+			name:               "SigmoidalContrastNegative",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"sigmoidal_contrast": "-3,50%"},
+			expectedParamError: "sigmoidal_contrast",
+		},
+		{
+			name:               "SigmoidalContrastZero",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"sigmoidal_contrast": "0"},
+			expectedParamError: "sigmoidal_contrast",
+		},
+		{
+			name:               "SigmoidalContrastMidPointOutOfRange",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"sigmoidal_contrast": "3,150%"},
+			expectedParamError: "sigmoidal_contrast",
+		},
+		{
+			name:               "SigmoidalContrastTooManyValues",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"sigmoidal_contrast": "3,50%,1"},
+			expectedParamError: "sigmoidal_contrast",
+		},
+		{
+			name:               "SigmoidalContrastInvalid",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"sigmoidal_contrast": "foo"},
+			expectedParamError: "sigmoidal_contrast",
+		},
+		{
+			name:               "SigmoidalContrastGraphicsMagick",
+			params:             imageserver.Params{"sigmoidal_contrast": "3,50%"},
+			expectedParamError: "sigmoidal_contrast",
+		},
+	})
+}
+
+func TestBuildArgumentsModulate(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "ModulateBrightness",
+			params:            imageserver.Params{"modulate": "120"},
+			expectedArguments: []string{"-modulate", "120,100,100"},
+		},
+		{
+			name:              "ModulateBrightnessSaturation",
+			params:            imageserver.Params{"modulate": "120,80"},
+			expectedArguments: []string{"-modulate", "120,80,100"},
+		},
+		{
+			name:              "ModulateBrightnessSaturationHue",
+			params:            imageserver.Params{"width": 100, "modulate": "120,80,50.5"},
+			expectedArguments: []string{"-resize", "100x", "-modulate", "120,80,50.5"},
+		},
+		{
+			name:               "ModulateBrightnessOutOfRange",
+			params:             imageserver.Params{"modulate": "201"},
+			expectedParamError: "modulate",
+		},
+		{
+			name:               "ModulateSaturationOutOfRange",
+			params:             imageserver.Params{"modulate": "100,-1"},
+			expectedParamError: "modulate",
+		},
+		{
+			name:               "ModulateInvalid",
+			params:             imageserver.Params{"modulate": "100,foo"},
+			expectedParamError: "modulate",
+		},
+		{
+			name:               "ModulateTooManyValues",
+			params:             imageserver.Params{"modulate": "100,100,100,100"},
+			expectedParamError: "modulate",
+		},
+		{
+			name:              "ModulateComponentsBrightness",
+			params:            imageserver.Params{"brightness": 150},
+			expectedArguments: []string{"-modulate", "150,100,100"},
+		},
+		{
+			name:              "ModulateComponentsSaturation",
+			params:            imageserver.Params{"saturation": 400},
+			expectedArguments: []string{"-modulate", "100,400,100"},
+		},
+		{
+			name:              "ModulateComponentsHue",
+			params:            imageserver.Params{"hue": 0},
+			expectedArguments: []string{"-modulate", "100,100,0"},
+		},
+		{
+			name:              "ModulateComponentsAll",
+			params:            imageserver.Params{"brightness": 120, "saturation": 80, "hue": 200},
+			expectedArguments: []string{"-modulate", "120,80,200"},
+		},
+		{
+			name:               "ModulateComponentsBrightnessOutOfRange",
+			params:             imageserver.Params{"brightness": 401},
+			expectedParamError: "brightness",
+		},
+		{
+			name:               "ModulateComponentsSaturationNegative",
+			params:             imageserver.Params{"saturation": -1},
+			expectedParamError: "saturation",
+		},
+		{
+			name:               "ModulateComponentsHueOutOfRange",
+			params:             imageserver.Params{"hue": 201},
+			expectedParamError: "hue",
+		},
+		{
+			name:               "ModulateComponentsInvalid",
+			params:             imageserver.Params{"hue": "foo"},
+			expectedParamError: "hue",
+		},
+		{
+			name:               "ModulateComponentsWithModulate",
+			params:             imageserver.Params{"modulate": "120", "hue": 100},
+			expectedParamError: "modulate",
+		},
+	})
+}
+
+func TestBuildArgumentsGamma(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Gamma",
+			params:            imageserver.Params{"gamma": "2.2"},
+			expectedArguments: []string{"-gamma", "2.2"},
+		},
+		{
+			name:              "GammaFloat",
+			params:            imageserver.Params{"gamma": 0.8},
+			expectedArguments: []string{"-gamma", "0.8"},
+		},
+		{
+			name:              "GammaChannels",
+			params:            imageserver.Params{"gamma": "1.7,2.3,1.2"},
+			expectedArguments: []string{"-gamma", "1.7,2.3,1.2"},
+		},
+		{
+			name:               "GammaZero",
+			params:             imageserver.Params{"gamma": "0"},
+			expectedParamError: "gamma",
+		},
+		{
+			name:               "GammaNegativeChannel",
+			params:             imageserver.Params{"gamma": "1,-1,1"},
+			expectedParamError: "gamma",
+		},
+		{
+			name:               "GammaInvalidChannelCount",
+			params:             imageserver.Params{"gamma": "1,1"},
+			expectedParamError: "gamma",
+		},
+		{
+			name:               "GammaInvalid",
+			params:             imageserver.Params{"gamma": "foo"},
+			expectedParamError: "gamma",
+		},
+		{
+			name:               "GammaInvalidType",
+			params:             imageserver.Params{"gamma": 1},
+			expectedParamError: "gamma",
+		},
+	})
+}
+
+func TestBuildArgumentsPosterize(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Posterize",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"posterize": 4},
+			expectedArguments: []string{"-posterize", "4"},
+		},
+		{
+			name:              "PosterizeAfterColorspace",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"posterize": 2, "colorspace": "gray"},
+			expectedArguments: []string{"-colorspace", "GRAY", "-posterize", "2"},
+		},
+		{
+			name:              "PosterizeMax",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"posterize": 255},
+			expectedArguments: []string{"-posterize", "255"},
+		},
+		{
+			name:               "PosterizeNegative",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"posterize": -2},
+			expectedParamError: "posterize",
+		},
+		{
+			name:               "PosterizeTooSmall",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"posterize": 1},
+			expectedParamError: "posterize",
+		},
+		{
+			name:               "PosterizeTooLarge",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"posterize": 256},
+			expectedParamError: "posterize",
+		},
+		{
+			name:               "PosterizeInvalidType",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"posterize": "4"},
+			expectedParamError: "posterize",
+		},
+		{
+			name:               "PosterizeGraphicsMagick",
+			params:             imageserver.Params{"posterize": 4},
+			expectedParamError: "posterize",
+		},
+	})
+}
+
+func TestBuildArgumentsThreshold(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Threshold",
+			params:            imageserver.Params{"threshold": 50},
+			expectedArguments: []string{"-threshold", "50%"},
+		},
+		{
+			name:              "BlackWhiteThreshold",
+			params:            imageserver.Params{"white_threshold": 90, "black_threshold": 10},
+			expectedArguments: []string{"-black-threshold", "10%", "-white-threshold", "90%"},
+		},
+		{
+			name:              "ThresholdAll",
+			params:            imageserver.Params{"threshold": 0, "black_threshold": 20, "white_threshold": 100},
+			expectedArguments: []string{"-threshold", "0%", "-black-threshold", "20%", "-white-threshold", "100%"},
+		},
+		{
+			name:              "ThresholdMax",
+			params:            imageserver.Params{"width": 100, "threshold": 100},
+			expectedArguments: []string{"-resize", "100x", "-threshold", "100%"},
+		},
+		{
+			name:               "ThresholdNegative",
+			params:             imageserver.Params{"threshold": -1},
+			expectedParamError: "threshold",
+		},
+		{
+			name:               "ThresholdInvalid",
+			params:             imageserver.Params{"threshold": 50.5},
+			expectedParamError: "threshold",
+		},
+		{
+			name:               "WhiteThresholdOutOfRange",
+			params:             imageserver.Params{"white_threshold": 101},
+			expectedParamError: "white_threshold",
+		},
+		{
+			name:               "ThresholdOutOfRange",
+			params:             imageserver.Params{"threshold": 101},
+			expectedParamError: "threshold",
+		},
+		{
+			name:               "BlackThresholdNegative",
+			params:             imageserver.Params{"black_threshold": -1},
+			expectedParamError: "black_threshold",
+		},
+		{
+			name:               "WhiteThresholdInvalid",
+			params:             imageserver.Params{"white_threshold": "50%"},
+			expectedParamError: "white_threshold",
+		},
+	})
+}
+
+func TestBuildArgumentsNegate(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Negate",
+			params:            imageserver.Params{"negate": true},
+			expectedArguments: []string{"-negate"},
+		},
+		{
+			name:   "NegateFalse",
+			params: imageserver.Params{"negate": false},
+		},
+		{
+			name:              "NegateGraysOnly",
+			params:            imageserver.Params{"negate_grays_only": true},
+			expectedArguments: []string{"+negate"},
+		},
+		{
+			name:               "NegateGraysOnlyWithNegate",
+			params:             imageserver.Params{"negate": true, "negate_grays_only": true},
+			expectedParamError: "negate_grays_only",
+		},
+		{
+			name:               "NegateInvalid",
+			params:             imageserver.Params{"negate": "foo"},
+			expectedParamError: "negate",
+		},
+		{
+			name:               "NegateGraysOnlyInvalid",
+			params:             imageserver.Params{"negate_grays_only": "foo"},
+			expectedParamError: "negate_grays_only",
+		},
+	})
+}
+
+func TestBuildArgumentsSepia(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "SepiaDefault",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"width": 100, "sepia": true},
+			expectedArguments: []string{"-resize", "100x", "-sepia-tone", "80%"},
+		},
+		{
+			name:    "SepiaFalse",
+			handler: &Handler{Backend: ImageMagick},
+			params:  imageserver.Params{"sepia": false},
+		},
+		{
+			name:              "SepiaPercent",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"sepia": 60},
+			expectedArguments: []string{"-sepia-tone", "60%"},
+		},
+		{
+			name:              "SepiaZero",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"sepia": 0},
+			expectedArguments: []string{"-sepia-tone", "0%"},
+		},
+		{
+			name:              "SepiaMax",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"sepia": 100},
+			expectedArguments: []string{"-sepia-tone", "100%"},
+		},
+		{
+			name:               "SepiaNegative",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"sepia": -1},
+			expectedParamError: "sepia",
+		},
+		{
+			name:               "SepiaOutOfRange",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"sepia": 101},
+			expectedParamError: "sepia",
+		},
+		{
+			name:               "SepiaInvalidType",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"sepia": "foo"},
+			expectedParamError: "sepia",
+		},
+		{
+			name:               "SepiaGraphicsMagick",
+			params:             imageserver.Params{"sepia": true},
+			expectedParamError: "sepia",
+		},
+	})
+}
+
+func TestBuildArgumentsVignette(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "VignetteImageMagick",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"width": 100, "vignette": true},
+			expectedArguments: []string{"-resize", "100x", "-vignette", "0x10"},
+		},
+		{
+			name:              "VignetteImageMagickGeometry",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"vignette": "2x5+10+20"},
+			expectedArguments: []string{"-vignette", "2x5+10+20"},
+		},
+		{
+			name:              "VignetteImageMagickRadiusSigma",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"vignette": "0,8.5"},
+			expectedArguments: []string{"-vignette", "0x8.5"},
+		},
+		{
+			name:              "VignetteImageMagickAfterBorder",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"border": "2", "vignette": true},
+			expectedArguments: []string{"-bordercolor", "#dfdfdf", "-border", "2x2", "-vignette", "0x10"},
+		},
+		{
+			name:    "VignetteImageMagickFalse",
+			handler: &Handler{Backend: ImageMagick},
+			params:  imageserver.Params{"vignette": false},
+		},
+		{
+			name:               "VignetteImageMagickNegativeSigma",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"vignette": "0x-1"},
+			expectedParamError: "vignette",
+		},
+		{
+			name:               "VignetteImageMagickNegativeRadius",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"vignette": "-1x10"},
+			expectedParamError: "vignette",
+		},
+		{
+			name:               "VignetteImageMagickNegativeOffset",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"vignette": "0x10+-5+5"},
+			expectedParamError: "vignette",
+		},
+		{
+			name:               "VignetteImageMagickMalformed",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"vignette": "0x10+5"},
+			expectedParamError: "vignette",
+		},
+		{
+			name:               "VignetteImageMagickNoSigma",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"vignette": "10"},
+			expectedParamError: "vignette",
+		},
+		{
+			name:               "VignetteImageMagickInvalidType",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"vignette": 10},
+			expectedParamError: "vignette",
+		},
+		{
+			name:               "VignetteImageMagickStrength",
+			handler:            &Handler{Backend: ImageMagick},
+			params:             imageserver.Params{"vignette": true, "vignette_strength": 50},
+			expectedParamError: "vignette_strength",
+		},
+	})
+}
+
+func TestBuildArgumentsSolarize(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "SolarizeDefault",
+			params:            imageserver.Params{"solarize": true},
+			expectedArguments: []string{"-solarize", "50%"},
+		},
+		{
+			name:   "SolarizeFalse",
+			params: imageserver.Params{"solarize": false},
+		},
+		{
+			name:              "SolarizePercent",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"width": 100, "sepia": true, "solarize": 30},
+			expectedArguments: []string{"-resize", "100x", "-sepia-tone", "80%", "-solarize", "30%"},
+		},
+		{
+			name:               "SolarizeOutOfRange",
+			params:             imageserver.Params{"solarize": -1},
+			expectedParamError: "solarize",
+		},
+		{
+			name:               "SolarizeInvalidType",
+			params:             imageserver.Params{"solarize": 1.5},
+			expectedParamError: "solarize",
+		},
+	})
+}
+
+func TestBuildArgumentsChop(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "ChopVertical",
+			params:            imageserver.Params{"chop": "10x0+50+0"},
+			expectedArguments: []string{"-chop", "10x0+50+0"},
+		},
+		{
+			name:              "ChopHorizontal",
+			params:            imageserver.Params{"width": 100, "shave": 2, "chop": "0x20"},
+			expectedArguments: []string{"-resize", "100x", "-shave", "2x2", "-chop", "0x20+0+0"},
+		},
+		{
+			name:   "ChopZero",
+			params: imageserver.Params{"chop": "0x0+10+10"},
+		},
+		{
+			name:               "ChopNegativeOffset",
+			params:             imageserver.Params{"chop": "10x0-5+0"},
+			expectedParamError: "chop",
+		},
+		{
+			name:               "ChopNegativeSize",
+			params:             imageserver.Params{"chop": "-10x0+5+0"},
+			expectedParamError: "chop",
+		},
+		{
+			name:               "ChopMissingOffset",
+			params:             imageserver.Params{"chop": "10x0+5"},
+			expectedParamError: "chop",
+		},
+		{
+			name:               "ChopMissingHeight",
+			params:             imageserver.Params{"chop": "10+5+0"},
+			expectedParamError: "chop",
+		},
+		{
+			name:               "ChopInvalid",
+			params:             imageserver.Params{"chop": "foo"},
+			expectedParamError: "chop",
+		},
+		{
+			name:               "ChopInvalidType",
+			params:             imageserver.Params{"chop": 10},
+			expectedParamError: "chop",
+		},
+	})
+}
+
+func TestBuildArgumentsShave(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Shave",
+			params:            imageserver.Params{"shave": "10x5"},
+			expectedArguments: []string{"-shave", "10x5"},
+		},
+		{
+			name:              "ShaveSize",
+			params:            imageserver.Params{"shave": "10"},
+			expectedArguments: []string{"-shave", "10x10"},
+		},
+		{
+			name:              "ShaveInt",
+			params:            imageserver.Params{"width": 100, "rotate": 90.0, "shave": 2, "blur": "1"},
+			expectedArguments: []string{"-resize", "100x", "-rotate", "90", "-shave", "2x2", "-blur", "0x1"},
+		},
+		{
+			name:   "ShaveZero",
+			params: imageserver.Params{"shave": "0x0"},
+		},
+		{
+			name:               "ShaveNegative",
+			params:             imageserver.Params{"shave": "10x-5"},
+			expectedParamError: "shave",
+		},
+		{
+			name:               "ShaveNegativeInt",
+			params:             imageserver.Params{"shave": -1},
+			expectedParamError: "shave",
+		},
+		{
+			name:               "ShaveTooManyValues",
+			params:             imageserver.Params{"shave": "1x2x3"},
+			expectedParamError: "shave",
+		},
+		{
+			name:               "ShaveInvalid",
+			params:             imageserver.Params{"shave": "foo"},
+			expectedParamError: "shave",
+		},
+		{
+			name:               "ShaveInvalidType",
+			params:             imageserver.Params{"shave": 1.5},
+			expectedParamError: "shave",
+		},
+	})
+}
+
+func TestBuildArgumentsBorder(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Border",
+			params:            imageserver.Params{"width": 100, "border": "2", "border_color": "f00"},
+			expectedArguments: []string{"-resize", "100x", "-bordercolor", "#f00", "-border", "2x2"},
+		},
+		{
+			name:              "BorderWidthHeight",
+			params:            imageserver.Params{"border": "2x4"},
+			expectedArguments: []string{"-bordercolor", "#dfdfdf", "-border", "2x4"},
+		},
+		{
+			name:              "BorderInt",
+			params:            imageserver.Params{"border": 3},
+			expectedArguments: []string{"-bordercolor", "#dfdfdf", "-border", "3x3"},
+		},
+		{
+			name:              "BorderDefaultColor",
+			params:            imageserver.Params{"border": "1"},
+			expectedArguments: []string{"-bordercolor", "#dfdfdf", "-border", "1x1"},
+		},
+		{
+			name:   "BorderZero",
+			params: imageserver.Params{"border": 0, "border_color": "000"},
+		},
+		{
+			name:   "BorderZeroString",
+			params: imageserver.Params{"border": "0x0"},
+		},
+		{
+			name:               "BorderZeroColorInvalid",
+			params:             imageserver.Params{"border": 0, "border_color": "black"},
+			expectedParamError: "border_color",
+		},
+		{
+			name:              "BorderAfterExtent",
+			params:            imageserver.Params{"width": 100, "height": 100, "extent": true, "border": 2},
+			expectedArguments: []string{"-resize", "100x100", "-gravity", "Center", "-extent", "100x100", "-bordercolor", "#dfdfdf", "-border", "2x2"},
+		},
+		{
+			name:               "BorderNegative",
+			params:             imageserver.Params{"border": "-1"},
+			expectedParamError: "border",
+		},
+		{
+			name:               "BorderNegativeInt",
+			params:             imageserver.Params{"border": -1},
+			expectedParamError: "border",
+		},
+		{
+			name:               "BorderInvalid",
+			params:             imageserver.Params{"border": "2xfoo"},
+			expectedParamError: "border",
+		},
+		{
+			name:               "BorderInvalidFormat",
+			params:             imageserver.Params{"border": "2x2x2"},
+			expectedParamError: "border",
+		},
+		{
+			name:               "BorderInvalidType",
+			params:             imageserver.Params{"border": 2.5},
+			expectedParamError: "border",
+		},
+		{
+			name:               "BorderColorInvalid",
+			params:             imageserver.Params{"border": 2, "border_color": "zzz"},
+			expectedParamError: "border_color",
+		},
+		{
+			name:               "BorderColorInvalidLength",
+			params:             imageserver.Params{"border": 2, "border_color": "ff"},
+			expectedParamError: "border_color",
+		},
+		{
+			name:               "BorderColorWithoutBorder",
+			params:             imageserver.Params{"border_color": "fff"},
+			expectedParamError: "border_color",
+		},
+		{
+			name:              "BorderColorAlias",
+			params:            imageserver.Params{"border": 2, "bordercolor": "ff0000"},
+			expectedArguments: []string{"-bordercolor", "#ff0000", "-border", "2x2"},
+		},
+		{
+			name:               "BorderColorAliasInvalid",
+			params:             imageserver.Params{"border": 2, "bordercolor": "zzz"},
+			expectedParamError: "bordercolor",
+		},
+		{
+			name:               "BorderColorAliasWithBorderColor",
+			params:             imageserver.Params{"border": 2, "border_color": "fff", "bordercolor": "fff"},
+			expectedParamError: "bordercolor",
+		},
+		{
+			name:               "BorderColorAliasWithoutBorder",
+			params:             imageserver.Params{"bordercolor": "fff"},
+			expectedParamError: "bordercolor",
+		},
+	})
+}
+
+func TestBuildArgumentsCharcoalEmboss(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Charcoal",
+			params:            imageserver.Params{"width": 100, "charcoal": 1.5},
+			expectedArguments: []string{"-resize", "100x", "-charcoal", "1.5"},
+		},
+		{
+			name:               "CharcoalOutOfRange",
+			params:             imageserver.Params{"charcoal": 20.5},
+			expectedParamError: "charcoal",
+		},
+		{
+			name:               "CharcoalInvalid",
+			params:             imageserver.Params{"charcoal": "foo"},
+			expectedParamError: "charcoal",
+		},
+		{
+			name:              "Emboss",
+			params:            imageserver.Params{"width": 100, "emboss": 2.0},
+			expectedArguments: []string{"-resize", "100x", "-emboss", "2"},
+		},
+		{
+			name:               "EmbossNegative",
+			params:             imageserver.Params{"emboss": -1.0},
+			expectedParamError: "emboss",
+		},
+		{
+			name:              "CharcoalEmbossColorspace",
+			params:            imageserver.Params{"colorspace": "GRAY", "charcoal": 1.0, "emboss": 1.0},
+			expectedArguments: []string{"-colorspace", "GRAY", "-charcoal", "1", "-emboss", "1"},
+		},
+	})
+}
+
+func TestBuildArgumentsExtent(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "ExtentGravity",
+			params:            imageserver.Params{"width": 100, "height": 100, "extent": true, "gravity": "northEast"},
+			expectedArguments: []string{"-resize", "100x100", "-gravity", "NorthEast", "-extent", "100x100"},
+		},
+		{
+			name:              "ExtentOffset",
+			params:            imageserver.Params{"width": 100, "height": 100, "extent": true, "extent_x": 10, "extent_y": -5},
+			expectedArguments: []string{"-resize", "100x100", "-gravity", "Center", "-extent", "100x100+10-5"},
+		},
+		{
+			name:              "ExtentOffsetX",
+			params:            imageserver.Params{"width": 100, "height": 100, "extent": true, "gravity": "nw", "extent_x": 20},
+			expectedArguments: []string{"-resize", "100x100", "-gravity", "NorthWest", "-extent", "100x100+20+0"},
+		},
+		{
+			name:              "ExtentWidth",
+			params:            imageserver.Params{"width": 100, "extent": true},
+			expectedArguments: []string{"-resize", "100x", "-gravity", "Center", "-extent", "100x80"},
+		},
+		{
+			name:              "ExtentHeight",
+			params:            imageserver.Params{"height": 100, "extent": true, "extent_y": 3},
+			expectedArguments: []string{"-resize", "x100", "-gravity", "Center", "-extent", "125x100+0+3"},
+		},
+		{
+			name:              "ExtentWidthCrop",
+			params:            imageserver.Params{"crop": "200,100,0,0", "width": 100, "extent": true},
+			expectedArguments: []string{"-crop", "200x100+0+0", "+repage", "-resize", "100x", "-gravity", "Center", "-extent", "100x50"},
+		},
+		{
+			name:              "ExtentWidthCropRatio",
+			params:            imageserver.Params{"crop_ratio": "1:1", "width": 100, "extent": true},
+			expectedArguments: []string{"-gravity", "Center", "-crop", "819x819+0+0", "+repage", "-resize", "100x", "-gravity", "Center", "-extent", "100x100"},
+		},
+		{
+			name:               "ExtentWithoutDimensions",
+			params:             imageserver.Params{"extent": true},
+			expectedParamError: "extent",
+		},
+		{
+			name:               "ExtentZeroDimensions",
+			params:             imageserver.Params{"width": 0, "height": 0, "extent": true},
+			expectedParamError: "extent",
+		},
+		{
+			name:               "ExtentResizePercent",
+			params:             imageserver.Params{"resize_percent": 50.0, "extent": true},
+			expectedParamError: "extent",
+		},
+		{
+			name:   "ZeroDimensions",
+			params: imageserver.Params{"width": 0, "height": 0},
+		},
+		{
+			name:   "ZeroDimensionsExtentFalse",
+			params: imageserver.Params{"width": 0, "height": 0, "extent": false},
+		},
+		{
+			name:               "ExtentOffsetWithoutExtent",
+			params:             imageserver.Params{"width": 100, "height": 100, "extent_x": 10},
+			expectedParamError: "extent_x",
+		},
+		{
+			name:               "ExtentOffsetInvalid",
+			params:             imageserver.Params{"width": 100, "height": 100, "extent": true, "extent_y": "10"},
+			expectedParamError: "extent_y",
+		},
+		{
+			name:               "ExtentGravityInvalid",
+			params:             imageserver.Params{"width": 100, "height": 100, "extent": true, "gravity": "foo"},
+			expectedParamError: "gravity",
+		},
+	})
+}
+
+func TestBuildArgumentsFit(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "FitCover",
+			params:            imageserver.Params{"width": 200, "height": 100, "fit": "cover"},
+			expectedArguments: []string{"-resize", "200x100^", "-gravity", "Center", "-extent", "200x100"},
+		},
+		{
+			name:              "FitCoverGravity",
+			params:            imageserver.Params{"width": 200, "height": 100, "fit": "cover", "gravity": "n"},
+			expectedArguments: []string{"-resize", "200x100^", "-gravity", "North", "-extent", "200x100"},
+		},
+		{
+			name:              "FitContain",
+			params:            imageserver.Params{"width": 200, "height": 100, "fit": "contain", "background": "000000"},
+			expectedArguments: []string{"-resize", "200x100", "-background", "#000000", "-gravity", "Center", "-extent", "200x100"},
+		},
+		{
+			name:              "FitFill",
+			params:            imageserver.Params{"width": 200, "height": 100, "fit": "fill"},
+			expectedArguments: []string{"-resize", "200x100!"},
+		},
+		{
+			name:              "FitInside",
+			params:            imageserver.Params{"width": 200, "height": 100, "fit": "inside"},
+			expectedArguments: []string{"-resize", "200x100"},
+		},
+		{
+			name:              "FitInsideWidth",
+			params:            imageserver.Params{"width": 200, "fit": "inside"},
+			expectedArguments: []string{"-resize", "200x"},
+		},
+		{
+			name:              "FitOutside",
+			params:            imageserver.Params{"width": 200, "height": 100, "fit": "outside"},
+			expectedArguments: []string{"-resize", "200x100^"},
+		},
+		{
+			name:              "FitOnlyShrinkLarger",
+			params:            imageserver.Params{"width": 200, "height": 100, "fit": "inside", "only_shrink_larger": true},
+			expectedArguments: []string{"-resize", "200x100>"},
+		},
+		{
+			name:              "FitDPR",
+			params:            imageserver.Params{"width": 200, "height": 100, "fit": "cover", "dpr": 2.0},
+			expectedArguments: []string{"-resize", "400x200^", "-gravity", "Center", "-extent", "400x200"},
+		},
+		{
+			name:               "FitInvalid",
+			params:             imageserver.Params{"width": 200, "height": 100, "fit": "crop"},
+			expectedParamError: "fit",
+		},
+		{
+			name:               "FitInvalidType",
+			params:             imageserver.Params{"width": 200, "height": 100, "fit": 1},
+			expectedParamError: "fit",
+		},
+		{
+			name:               "FitWithoutDimensions",
+			params:             imageserver.Params{"fit": "inside"},
+			expectedParamError: "fit",
+		},
+		{
+			name:               "FitCoverWithoutHeight",
+			params:             imageserver.Params{"width": 200, "fit": "cover"},
+			expectedParamError: "fit",
+		},
+		{
+			name:               "FitFillWithoutWidth",
+			params:             imageserver.Params{"height": 100, "fit": "fill"},
+			expectedParamError: "fit",
+		},
+		{
+			name:               "FitWithFill",
+			params:             imageserver.Params{"width": 200, "height": 100, "fit": "cover", "fill": true},
+			expectedParamError: "fill",
+		},
+		{
+			name:               "FitWithIgnoreRatio",
+			params:             imageserver.Params{"width": 200, "height": 100, "fit": "inside", "ignore_ratio": false},
+			expectedParamError: "ignore_ratio",
+		},
+		{
+			name:               "FitWithExtent",
+			params:             imageserver.Params{"width": 200, "height": 100, "fit": "contain", "extent": true},
+			expectedParamError: "extent",
+		},
+	})
+}
+
+func TestBuildArgumentsPad(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:               "PadZeroDimensions",
+			params:             imageserver.Params{"width": 0, "height": 0, "pad": true},
+			expectedParamError: "pad",
+		},
+		{
+			name:              "PadPNG",
+			params:            imageserver.Params{"width": 100, "height": 100, "pad": true, "format": "png"},
+			expectedArguments: []string{"-resize", "100x100", "-background", "none", "-gravity", "Center", "-extent", "100x100", "-format", "png"},
+		},
+		{
+			name:              "PadPNGTransparentBackground",
+			params:            imageserver.Params{"width": 100, "height": 100, "pad": true, "background": "ffffff00", "format": "png"},
+			expectedArguments: []string{"-resize", "100x100", "-background", "#ffffff00", "-gravity", "Center", "-extent", "100x100", "-format", "png"},
+		},
+		{
+			name:              "PadJPEG",
+			params:            imageserver.Params{"width": 100, "height": 100, "pad": true},
+			expectedArguments: []string{"-resize", "100x100", "-background", "#ffffff", "-gravity", "Center", "-extent", "100x100"},
+		},
+		{
+			name:              "PadJPEGBackground",
+			params:            imageserver.Params{"width": 100, "height": 100, "pad": true, "background": "ff0000", "gravity": "s", "format": "jpeg"},
+			expectedArguments: []string{"-resize", "100x100", "-background", "#ff0000", "-gravity", "South", "-extent", "100x100", "-format", "jpeg"},
+		},
+		{
+			name:              "PadHeight",
+			params:            imageserver.Params{"height": 100, "pad": true, "background": "000"},
+			expectedArguments: []string{"-resize", "x100", "-background", "#000", "-gravity", "Center", "-extent", "125x100"},
+		},
+		{
+			name:              "PadOffset",
+			params:            imageserver.Params{"width": 100, "height": 100, "pad": true, "background": "000", "extent_x": 5},
+			expectedArguments: []string{"-resize", "100x100", "-background", "#000", "-gravity", "Center", "-extent", "100x100+5+0"},
+		},
+		{
+			name:              "PadFalse",
+			params:            imageserver.Params{"width": 100, "height": 100, "pad": false},
+			expectedArguments: []string{"-resize", "100x100"},
+		},
+		{
+			name:               "PadWithExtent",
+			params:             imageserver.Params{"width": 100, "height": 100, "pad": true, "extent": true},
+			expectedParamError: "pad",
+		},
+		{
+			name:               "PadWithFit",
+			params:             imageserver.Params{"width": 100, "height": 100, "pad": true, "fit": "inside"},
+			expectedParamError: "pad",
+		},
+		{
+			name:               "PadInvalid",
+			params:             imageserver.Params{"width": 100, "height": 100, "pad": "true"},
+			expectedParamError: "pad",
+		},
+	})
+}
+
+func TestBuildArgumentsDistortions(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Swirl",
+			handler:           &Handler{AllowDistortions: true},
+			params:            imageserver.Params{"swirl": -90.0},
+			expectedArguments: []string{"-swirl", "-90"},
+		},
+		{
+			name:               "SwirlOutOfRange",
+			handler:            &Handler{AllowDistortions: true},
+			params:             imageserver.Params{"swirl": 360.5},
+			expectedParamError: "swirl",
+		},
+		{
+			name:               "SwirlNotAllowed",
+			params:             imageserver.Params{"swirl": 90.0},
+			expectedParamError: "swirl",
+		},
+		{
+			name:               "SwirlInvalid",
+			handler:            &Handler{AllowDistortions: true},
+			params:             imageserver.Params{"swirl": "90"},
+			expectedParamError: "swirl",
+		},
+		{
+			name:              "Implode",
+			handler:           &Handler{AllowDistortions: true},
+			params:            imageserver.Params{"implode": 0.5},
+			expectedArguments: []string{"-implode", "0.5"},
+		},
+		{
+			name:               "ImplodeOutOfRange",
+			handler:            &Handler{AllowDistortions: true},
+			params:             imageserver.Params{"implode": -1.5},
+			expectedParamError: "implode",
+		},
+		{
+			name:               "ImplodeNotAllowed",
+			params:             imageserver.Params{"implode": 0.5},
+			expectedParamError: "implode",
+		},
+		{
+			name:              "Wave",
+			handler:           &Handler{AllowDistortions: true},
+			params:            imageserver.Params{"width": 100, "wave": "10,50.5"},
+			expectedArguments: []string{"-resize", "100x", "-wave", "10x50.5"},
+		},
+		{
+			name:              "Distortions",
+			handler:           &Handler{AllowDistortions: true},
+			params:            imageserver.Params{"wave": "5,20", "implode": -0.25, "swirl": 45.0},
+			expectedArguments: []string{"-swirl", "45", "-implode", "-0.25", "-wave", "5x20"},
+		},
+		{
+			name:               "WaveNegative",
+			handler:            &Handler{AllowDistortions: true},
+			params:             imageserver.Params{"wave": "-10,50"},
+			expectedParamError: "wave",
+		},
+		{
+			name:               "WaveZeroWavelength",
+			handler:            &Handler{AllowDistortions: true},
+			params:             imageserver.Params{"wave": "10,0"},
+			expectedParamError: "wave",
+		},
+		{
+			name:               "WaveInvalidFormat",
+			handler:            &Handler{AllowDistortions: true},
+			params:             imageserver.Params{"wave": "10"},
+			expectedParamError: "wave",
+		},
+		{
+			name:               "WaveInvalid",
+			handler:            &Handler{AllowDistortions: true},
+			params:             imageserver.Params{"wave": "foo,50"},
+			expectedParamError: "wave",
+		},
+		{
+			name:               "WaveNotAllowed",
+			params:             imageserver.Params{"wave": "10,50"},
+			expectedParamError: "wave",
+		},
+	})
+}
+
+func TestBuildArgumentsRoll(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Roll",
+			params:            imageserver.Params{"roll": "-10-20"},
+			expectedArguments: []string{"-roll", "-10-20"},
+		},
+		{
+			name:              "RollPositive",
+			params:            imageserver.Params{"roll": "+10+20"},
+			expectedArguments: []string{"-roll", "+10+20"},
+		},
+		{
+			name:              "RollMixed",
+			params:            imageserver.Params{"roll": "+10-20"},
+			expectedArguments: []string{"-roll", "+10-20"},
+		},
+		{
+			name:               "RollMissingY",
+			params:             imageserver.Params{"roll": "+10"},
+			expectedParamError: "roll",
+		},
+		{
+			name:               "RollMissingSign",
+			params:             imageserver.Params{"roll": "10+20"},
+			expectedParamError: "roll",
+		},
+		{
+			name:              "RollComma",
+			params:            imageserver.Params{"width": 100, "roll": "10,-20"},
+			expectedArguments: []string{"-resize", "100x", "-roll", "+10-20"},
+		},
+		{
+			name:               "RollInvalid",
+			params:             imageserver.Params{"roll": "+1.5+2"},
+			expectedParamError: "roll",
+		},
+		{
+			name:               "RollInvalidType",
+			params:             imageserver.Params{"roll": 10},
+			expectedParamError: "roll",
+		},
+	})
+}
+
+func TestBuildArgumentsShear(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Shear",
+			params:            imageserver.Params{"shear": "10"},
+			expectedArguments: []string{"-background", "#ffffff", "-shear", "10"},
+		},
+		{
+			name:              "ShearXY",
+			params:            imageserver.Params{"shear": "-20.5,89"},
+			expectedArguments: []string{"-background", "#ffffff", "-shear", "-20.5x89"},
+		},
+		{
+			name:              "ShearBackground",
+			params:            imageserver.Params{"width": 100, "shear": "10,5", "background": "000"},
+			expectedArguments: []string{"-resize", "100x", "-background", "#000", "-shear", "10x5"},
+		},
+		{
+			name:               "ShearOutOfRange",
+			params:             imageserver.Params{"shear": "90"},
+			expectedParamError: "shear",
+		},
+		{
+			name:               "ShearYOutOfRange",
+			params:             imageserver.Params{"shear": "0,-90"},
+			expectedParamError: "shear",
+		},
+		{
+			name:               "ShearInvalidAngle",
+			params:             imageserver.Params{"shear": "10deg"},
+			expectedParamError: "shear",
+		},
+		{
+			name:               "ShearTooManyAngles",
+			params:             imageserver.Params{"shear": "1,2,3"},
+			expectedParamError: "shear",
+		},
+		{
+			name:               "ShearInvalidType",
+			params:             imageserver.Params{"shear": 10},
+			expectedParamError: "shear",
+		},
+	})
+}
+
+func TestBuildArgumentsFlatten(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Flatten",
+			params:            imageserver.Params{"flatten": true, "format": "jpeg"},
+			expectedArguments: []string{"-background", "#ffffff", "-flatten", "-format", "jpeg"},
+		},
+		{
+			name:              "FlattenBackground",
+			params:            imageserver.Params{"flatten": true, "background": "ff0000", "format": "jpeg"},
+			expectedArguments: []string{"-background", "#ff0000", "-flatten", "-format", "jpeg"},
+		},
+		{
+			name:              "FlattenBackgroundShear",
+			params:            imageserver.Params{"flatten": true, "background": "000", "shear": "10"},
+			expectedArguments: []string{"-background", "#000", "-flatten", "-shear", "10"},
+		},
+		{
+			name:   "FlattenFalse",
+			params: imageserver.Params{"flatten": false},
+		},
+		{
+			name:               "FlattenInvalid",
+			params:             imageserver.Params{"flatten": "true"},
+			expectedParamError: "flatten",
+		},
+	})
+}
+
+func TestBuildArgumentsTransparent(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Transparent",
+			params:            imageserver.Params{"transparent": "ffffff", "format": "png"},
+			expectedArguments: []string{"-transparent", "#ffffff", "-format", "png"},
+		},
+		{
+			name:              "TransparentFuzz",
+			params:            imageserver.Params{"width": 100, "transparent": "fff", "transparent_fuzz": 5.0, "format": "png"},
+			expectedArguments: []string{"-resize", "100x", "-fuzz", "5%", "-transparent", "#fff", "-fuzz", "0%", "-format", "png"},
+		},
+		{
+			name:              "TransparentFuzzTrimFuzz",
+			params:            imageserver.Params{"trim": true, "trim_fuzz": 1.0, "transparent": "fff", "transparent_fuzz": 5.0, "format": "png"},
+			expectedArguments: []string{"-fuzz", "1%", "-trim", "+repage", "-fuzz", "0%", "-fuzz", "5%", "-transparent", "#fff", "-fuzz", "0%", "-format", "png"},
+		},
+		{
+			name:               "TransparentInvalidColor",
+			params:             imageserver.Params{"transparent": "white"},
+			expectedParamError: "transparent",
+		},
+		{
+			name:               "TransparentInvalid",
+			params:             imageserver.Params{"transparent": 1},
+			expectedParamError: "transparent",
+		},
+		{
+			name:               "TransparentFuzzWithoutTransparent",
+			params:             imageserver.Params{"transparent_fuzz": 5.0},
+			expectedParamError: "transparent_fuzz",
+		},
+		{
+			name:               "TransparentFuzzOutOfRange",
+			params:             imageserver.Params{"transparent": "fff", "transparent_fuzz": 100.5},
+			expectedParamError: "transparent_fuzz",
+		},
+		{
+			name:               "TransparentFuzzInvalid",
+			params:             imageserver.Params{"transparent": "fff", "transparent_fuzz": "5%"},
+			expectedParamError: "transparent_fuzz",
+		},
+	})
+}
+
+func TestBuildArgumentsAlpha(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "AlphaKeep",
+			params:            imageserver.Params{"alpha": "keep", "format": "png"},
+			expectedArguments: []string{"-format", "png"},
+		},
+		{
+			name:              "AlphaRemove",
+			params:            imageserver.Params{"alpha": "remove", "format": "png"},
+			expectedArguments: []string{"-background", "#ffffff", "-flatten", "+matte", "-format", "png"},
+		},
+		{
+			name:              "AlphaRemoveBackground",
+			params:            imageserver.Params{"alpha": "remove", "background": "000", "format": "png"},
+			expectedArguments: []string{"-background", "#000", "-flatten", "+matte", "-format", "png"},
+		},
+		{
+			name:              "AlphaOpaque",
+			params:            imageserver.Params{"alpha": "opaque", "format": "png"},
+			expectedArguments: []string{"+matte", "-format", "png"},
+		},
+		{
+			name:              "AlphaOpaqueImageMagick",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"alpha": "opaque", "format": "png"},
+			expectedArguments: []string{"-alpha", "off", "-format", "png"},
+		},
+		{
+			name:               "AlphaRemoveFlatten",
+			params:             imageserver.Params{"alpha": "remove", "flatten": false},
+			expectedParamError: "flatten",
+		},
+		{
+			name:               "AlphaUnknown",
+			params:             imageserver.Params{"alpha": "on"},
+			expectedParamError: "alpha",
+		},
+		{
+			name:               "AlphaInvalid",
+			params:             imageserver.Params{"alpha": true},
+			expectedParamError: "alpha",
+		},
+	})
+}
+
+func TestBuildArgumentsOilPaint(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "OilPaint",
+			handler:           &Handler{AllowExpensiveFilters: true},
+			params:            imageserver.Params{"width": 100, "oil_paint": 3},
+			expectedArguments: []string{"-resize", "100x", "-paint", "3"},
+		},
+		{
+			name:               "OilPaintOutOfRange",
+			handler:            &Handler{AllowExpensiveFilters: true},
+			params:             imageserver.Params{"oil_paint": 11},
+			expectedParamError: "oil_paint",
+		},
+		{
+			name:               "OilPaintZero",
+			handler:            &Handler{AllowExpensiveFilters: true},
+			params:             imageserver.Params{"oil_paint": 0},
+			expectedParamError: "oil_paint",
+		},
+		{
+			name:               "OilPaintNotAllowed",
+			params:             imageserver.Params{"oil_paint": 3},
+			expectedParamError: "oil_paint",
+		},
+	})
+}
+
+func TestBuildArgumentsColors(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Colors",
+			params:            imageserver.Params{"colors": 16, "format": "png"},
+			expectedArguments: []string{"-colors", "16", "-format", "png"},
+		},
+		{
+			name:              "ColorsDither",
+			params:            imageserver.Params{"colors": 256, "dither": true, "format": "gif"},
+			expectedArguments: []string{"-dither", "-colors", "256", "-format", "gif"},
+		},
+		{
+			name:              "ColorsNoDither",
+			params:            imageserver.Params{"colors": 2, "dither": false, "format": "png"},
+			expectedArguments: []string{"+dither", "-colors", "2", "-format", "png"},
+		},
+		{
+			name:              "ColorsAfterPosterize",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"colors": 8, "posterize": 4, "format": "png"},
+			expectedArguments: []string{"-posterize", "4", "-colors", "8", "-format", "png"},
+		},
+		{
+			name:               "ColorsTooSmall",
+			params:             imageserver.Params{"colors": 1, "format": "png"},
+			expectedParamError: "colors",
+		},
+		{
+			name:               "ColorsTooLarge",
+			params:             imageserver.Params{"colors": 257, "format": "png"},
+			expectedParamError: "colors",
+		},
+		{
+			name:               "ColorsInvalidType",
+			params:             imageserver.Params{"colors": "16", "format": "png"},
+			expectedParamError: "colors",
+		},
+		{
+			name:               "ColorsJPEG",
+			params:             imageserver.Params{"colors": 16, "format": "jpeg"},
+			expectedParamError: "colors",
+		},
+		{
+			name:               "ColorsJPEGSource",
+			params:             imageserver.Params{"colors": 16},
+			expectedParamError: "colors",
+		},
+		{
+			name:               "DitherWithoutColors",
+			params:             imageserver.Params{"dither": true},
+			expectedParamError: "dither",
+		},
+		{
+			name:               "DitherInvalidType",
+			params:             imageserver.Params{"colors": 16, "dither": "true", "format": "png"},
+			expectedParamError: "dither",
+		},
+	})
+}
+
+func TestBuildArgumentsStrip(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Strip",
+			params:            imageserver.Params{"width": 100, "strip": true},
+			expectedArguments: []string{"-resize", "100x", "-strip"},
+		},
+		{
+			name:              "StripAfterAutoOrient",
+			params:            imageserver.Params{"width": 100, "strip": true, "auto_orient": true},
+			expectedArguments: []string{"-auto-orient", "-resize", "100x", "-strip"},
+		},
+		{
+			name:   "StripFalse",
+			params: imageserver.Params{"strip": false},
+		},
+		{
+			name:              "StripAlways",
+			handler:           &Handler{AlwaysStrip: true},
+			params:            imageserver.Params{"strip": false},
+			expectedArguments: []string{"-strip"},
+		},
+		{
+			name:              "StripAlwaysNoParams",
+			handler:           &Handler{AlwaysStrip: true},
+			params:            imageserver.Params{},
+			expectedArguments: []string{"-strip"},
+		},
+		{
+			name:              "StripKeepICC",
+			params:            imageserver.Params{"strip": true, "keep_icc": true},
+			expectedArguments: []string{"+profile", "8bim", "+profile", "exif", "+profile", "iptc", "+profile", "xmp"},
+		},
+		{
+			name:   "KeepICCWithoutStrip",
+			params: imageserver.Params{"keep_icc": true},
+		},
+		{
+			name:              "StripKeepProfilesICC",
+			params:            imageserver.Params{"width": 100, "strip": true, "keep_profiles": "icc"},
+			expectedArguments: []string{"-resize", "100x", "+profile", "8bim", "+profile", "exif", "+profile", "iptc", "+profile", "xmp"},
+		},
+		{
+			name:              "StripKeepProfilesICM",
+			params:            imageserver.Params{"strip": true, "keep_profiles": "icm"},
+			expectedArguments: []string{"+profile", "8bim", "+profile", "exif", "+profile", "iptc", "+profile", "xmp"},
+		},
+		{
+			name:              "StripKeepProfilesMultiple",
+			params:            imageserver.Params{"strip": true, "keep_profiles": "xmp,exif"},
+			expectedArguments: []string{"+profile", "8bim", "+profile", "icc", "+profile", "iptc"},
+		},
+		{
+			name:              "StripKeepProfilesKeepICC",
+			params:            imageserver.Params{"strip": true, "keep_profiles": "iptc", "keep_icc": true},
+			expectedArguments: []string{"+profile", "8bim", "+profile", "exif", "+profile", "xmp"},
+		},
+		{
+			name:              "StripKeepProfilesOrder",
+			params:            imageserver.Params{"strip": true, "keep_profiles": "icc", "negate": true, "format": "png"},
+			expectedArguments: []string{"-negate", "+profile", "8bim", "+profile", "exif", "+profile", "iptc", "+profile", "xmp", "-format", "png"},
+		},
+		{
+			name:   "KeepProfilesWithoutStrip",
+			params: imageserver.Params{"keep_profiles": "icc"},
+		},
+		{
+			name:               "KeepProfilesUnknown",
+			params:             imageserver.Params{"strip": true, "keep_profiles": "icc,foo"},
+			expectedParamError: "keep_profiles",
+		},
+		{
+			name:               "KeepProfilesEmpty",
+			params:             imageserver.Params{"strip": true, "keep_profiles": "icc,"},
+			expectedParamError: "keep_profiles",
+		},
+		{
+			name:               "KeepProfilesInvalid",
+			params:             imageserver.Params{"strip": true, "keep_profiles": true},
+			expectedParamError: "keep_profiles",
+		},
+		{
+			name:               "StripInvalid",
+			params:             imageserver.Params{"strip": "foo"},
+			expectedParamError: "strip",
+		},
+		{
+			name:               "KeepICCInvalid",
+			params:             imageserver.Params{"strip": true, "keep_icc": "foo"},
+			expectedParamError: "keep_icc",
+		},
+	})
+}
+
+func TestBuildArgumentsFormat(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "PNG8",
+			params:            imageserver.Params{"format": "png", "png8": true},
+			expectedArguments: []string{"-format", "png8"},
+		},
+		{
+			name:              "PNG8False",
+			params:            imageserver.Params{"format": "png", "png8": false},
+			expectedArguments: []string{"-format", "png"},
+		},
+		{
+			name:               "PNG8JPEG",
+			params:             imageserver.Params{"format": "jpeg", "png8": true},
+			expectedParamError: "png8",
+		},
+		{
+			name:               "PNG8JPEGSource",
+			params:             imageserver.Params{"png8": true},
+			expectedParamError: "png8",
+		},
+		{
+			name:               "PNG8Invalid",
+			params:             imageserver.Params{"format": "png", "png8": "true"},
+			expectedParamError: "png8",
+		},
+		{
+			name:               "FormatAlias",
+			params:             imageserver.Params{"format": "jpg", "quality": 101},
+			expectedParamError: "quality",
+		},
+		{
+			name:              "FormatAliasTIFF",
+			params:            imageserver.Params{"format": "TIF"},
+			expectedArguments: []string{"-format", "tiff"},
+		},
+		{
+			name:              "FormatUpperCase",
+			params:            imageserver.Params{"format": "PNG"},
+			expectedArguments: []string{"-format", "png"},
+		},
+		{
+			name:              "FormatAliasAllowedFormats",
+			handler:           &Handler{AllowedFormats: []string{"jpeg", "png"}},
+			params:            imageserver.Params{"format": "JPG"},
+			expectedArguments: []string{"-format", "jpeg"},
+		},
+		{
+			name:              "FormatAllowedFormatsCaseInsensitive",
+			handler:           &Handler{AllowedFormats: []string{"JPEG", "PNG"}},
+			params:            imageserver.Params{"format": "png"},
+			expectedArguments: []string{"-format", "png"},
+		},
+		{
+			name:               "FormatNotAllowed",
+			handler:            &Handler{AllowedFormats: []string{"jpeg", "png"}},
+			params:             imageserver.Params{"format": "tif"},
+			expectedParamError: "format",
+		},
+		{
+			name:              "FormatAliasesOverride",
+			handler:           &Handler{FormatAliases: map[string]string{"jpg": "png", "pjpeg": "jpeg"}},
+			params:            imageserver.Params{"format": "JPG"},
+			expectedArguments: []string{"-format", "png"},
+		},
+		{
+			name:               "FormatAliasesCustom",
+			handler:            &Handler{FormatAliases: map[string]string{"pjpeg": "jpeg"}},
+			params:             imageserver.Params{"format": "pjpeg", "colors": 16},
+			expectedParamError: "colors",
+		},
+	})
+}
+
+func TestBuildArgumentsQuality(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Quality",
+			params:            imageserver.Params{"quality": 85},
+			expectedArguments: []string{"-quality", "85"},
+		},
+		{
+			name:              "QualityDefault",
+			handler:           &Handler{DefaultQuality: map[string]int{"jpeg": 75, "webp": 80}},
+			params:            imageserver.Params{"width": 100},
+			expectedArguments: []string{"-resize", "100x", "-quality", "75"},
+		},
+		{
+			name:              "QualityDefaultWebP",
+			handler:           &Handler{DefaultQuality: map[string]int{"jpeg": 75, "webp": 80}},
+			params:            imageserver.Params{"format": "webp"},
+			expectedArguments: []string{"-format", "webp", "-quality", "80"},
+		},
+		{
+			name:              "QualityDefaultFormatNotSet",
+			handler:           &Handler{DefaultQuality: map[string]int{"webp": 80}},
+			params:            imageserver.Params{"format": "jpeg"},
+			expectedArguments: []string{"-format", "jpeg"},
+		},
+		{
+			name:              "QualityDefaultOverride",
+			handler:           &Handler{DefaultQuality: map[string]int{"jpeg": 75}},
+			params:            imageserver.Params{"quality": 90},
+			expectedArguments: []string{"-quality", "90"},
+		},
+		{
+			name:               "QualityNegative",
+			params:             imageserver.Params{"quality": -1},
+			expectedParamError: "quality",
+		},
+		{
+			name:               "QualityJPEGOutOfRange",
+			params:             imageserver.Params{"quality": 101},
+			expectedParamError: "quality",
+		},
+		{
+			name:               "QualityWebPOutOfRange",
+			params:             imageserver.Params{"format": "webp", "quality": 101},
+			expectedParamError: "quality",
+		},
+		{
+			name:              "QualityPNG",
+			params:            imageserver.Params{"format": "png", "quality": 105},
+			expectedArguments: []string{"-format", "png", "-quality", "105"},
+		},
+		{
+			name:               "QualityInvalid",
+			params:             imageserver.Params{"quality": "foo"},
+			expectedParamError: "quality",
+		},
+	})
+}
+
+func TestBuildArgumentsSamplingFactor(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "SamplingFactor444",
+			params:            imageserver.Params{"format": "jpeg", "sampling_factor": "4:4:4"},
+			expectedArguments: []string{"-format", "jpeg", "-sampling-factor", "1x1"},
+		},
+		{
+			name:              "SamplingFactor422",
+			params:            imageserver.Params{"width": 100, "sampling_factor": "4:2:2"},
+			expectedArguments: []string{"-resize", "100x", "-sampling-factor", "2x1"},
+		},
+		{
+			name:              "SamplingFactor420",
+			params:            imageserver.Params{"format": "jpeg", "quality": 80, "sampling_factor": "4:2:0"},
+			expectedArguments: []string{"-format", "jpeg", "-quality", "80", "-sampling-factor", "2x2"},
+		},
+		{
+			name:               "SamplingFactorPNG",
+			params:             imageserver.Params{"format": "png", "sampling_factor": "4:2:0"},
+			expectedParamError: "sampling_factor",
+		},
+		{
+			name:               "SamplingFactorUnknown",
+			params:             imageserver.Params{"format": "jpeg", "sampling_factor": "2x2"},
+			expectedParamError: "sampling_factor",
+		},
+		{
+			name:               "SamplingFactorInvalid",
+			params:             imageserver.Params{"format": "jpeg", "sampling_factor": 420},
+			expectedParamError: "sampling_factor",
+		},
+		{
+			name:              "SamplingFactorDefault",
+			handler:           &Handler{DefaultSamplingFactor: "4:2:0"},
+			params:            imageserver.Params{"format": "jpeg"},
+			expectedArguments: []string{"-format", "jpeg", "-sampling-factor", "2x2"},
+		},
+		{
+			name:              "SamplingFactorDefaultOverride",
+			handler:           &Handler{DefaultSamplingFactor: "4:2:0"},
+			params:            imageserver.Params{"format": "jpeg", "sampling_factor": "4:4:4"},
+			expectedArguments: []string{"-format", "jpeg", "-sampling-factor", "1x1"},
+		},
+		{
+			name:              "SamplingFactorDefaultPNG",
+			handler:           &Handler{DefaultSamplingFactor: "4:2:0"},
+			params:            imageserver.Params{"format": "png"},
+			expectedArguments: []string{"-format", "png"},
+		},
+	})
+}
+
+func TestBuildArgumentsWebP(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "WebPLossless",
+			params:            imageserver.Params{"format": "webp", "webp_lossless": true},
+			expectedArguments: []string{"-format", "webp", "-define", "webp:lossless=true"},
+		},
+		{
+			name:              "WebPLosslessFalse",
+			params:            imageserver.Params{"format": "webp", "webp_lossless": false},
+			expectedArguments: []string{"-format", "webp"},
+		},
+		{
+			name:              "WebPAlphaQuality",
+			params:            imageserver.Params{"format": "webp", "quality": 80, "webp_lossless": true, "webp_alpha_quality": 50},
+			expectedArguments: []string{"-format", "webp", "-quality", "80", "-define", "webp:lossless=true", "-define", "webp:alpha-quality=50"},
+		},
+		{
+			name:               "WebPLosslessJPEG",
+			params:             imageserver.Params{"webp_lossless": true},
+			expectedParamError: "webp_lossless",
+		},
+		{
+			name:               "WebPLosslessInvalid",
+			params:             imageserver.Params{"format": "webp", "webp_lossless": "true"},
+			expectedParamError: "webp_lossless",
+		},
+		{
+			name:               "WebPAlphaQualityPNG",
+			params:             imageserver.Params{"format": "png", "webp_alpha_quality": 50},
+			expectedParamError: "webp_alpha_quality",
+		},
+		{
+			name:               "WebPAlphaQualityOutOfRange",
+			params:             imageserver.Params{"format": "webp", "webp_alpha_quality": 101},
+			expectedParamError: "webp_alpha_quality",
+		},
+		{
+			name:               "WebPAlphaQualityInvalid",
+			params:             imageserver.Params{"format": "webp", "webp_alpha_quality": "50"},
+			expectedParamError: "webp_alpha_quality",
+		},
+	})
+}
+
+func TestBuildArgumentsMaxBytes(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "MaxBytes",
+			params:            imageserver.Params{"format": "jpeg", "quality": 90, "max_bytes": 51200},
+			expectedArguments: []string{"-format", "jpeg", "-quality", "90", "-define", "jpeg:extent=50KB"},
+		},
+		{
+			name:              "MaxBytesRoundDown",
+			params:            imageserver.Params{"width": 100, "max_bytes": 2047},
+			expectedArguments: []string{"-resize", "100x", "-define", "jpeg:extent=1KB"},
+		},
+		{
+			name:              "MaxBytesPNG",
+			params:            imageserver.Params{"format": "png", "max_bytes": 51200},
+			expectedArguments: []string{"-format", "png"},
+		},
+		{
+			name:               "MaxBytesTooSmall",
+			params:             imageserver.Params{"format": "jpeg", "max_bytes": 1023},
+			expectedParamError: "max_bytes",
+		},
+		{
+			name:               "MaxBytesNegative",
+			params:             imageserver.Params{"format": "png", "max_bytes": -1},
+			expectedParamError: "max_bytes",
+		},
+		{
+			name:               "MaxBytesInvalid",
+			params:             imageserver.Params{"format": "jpeg", "max_bytes": "50KB"},
+			expectedParamError: "max_bytes",
+		},
+	})
+}
+
+func TestBuildArgumentsPNGCompression(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "PNGCompression",
+			params:            imageserver.Params{"format": "png", "png_compression": 9},
+			expectedArguments: []string{"-format", "png", "-quality", "95"},
+		},
+		{
+			name:              "PNGCompressionZero",
+			params:            imageserver.Params{"format": "png", "png_compression": 0, "depth": 8},
+			expectedArguments: []string{"-format", "png", "-quality", "5", "-depth", "8"},
+		},
+		{
+			name:              "PNGCompressionDefaultQuality",
+			handler:           &Handler{DefaultQuality: map[string]int{"png": 75}},
+			params:            imageserver.Params{"format": "png", "png_compression": 3},
+			expectedArguments: []string{"-format", "png", "-quality", "35"},
+		},
+		{
+			name:              "PNGCompressionImageMagick",
+			handler:           &Handler{Backend: ImageMagick},
+			params:            imageserver.Params{"format": "png", "png_compression": 9},
+			expectedArguments: []string{"-format", "png", "-define", "png:compression-level=9"},
+		},
+		{
+			name:               "PNGCompressionQuality",
+			params:             imageserver.Params{"format": "png", "quality": 95, "png_compression": 9},
+			expectedParamError: "png_compression",
+		},
+		{
+			name:               "PNGCompressionJPEG",
+			params:             imageserver.Params{"format": "jpeg", "png_compression": 6},
+			expectedParamError: "png_compression",
+		},
+		{
+			name:               "PNGCompressionJPEGSource",
+			params:             imageserver.Params{"width": 100, "png_compression": 6},
+			expectedParamError: "png_compression",
+		},
+		{
+			name:               "PNGCompressionTooLarge",
+			params:             imageserver.Params{"format": "png", "png_compression": 10},
+			expectedParamError: "png_compression",
+		},
+		{
+			name:               "PNGCompressionNegative",
+			params:             imageserver.Params{"format": "png", "png_compression": -1},
+			expectedParamError: "png_compression",
+		},
+		{
+			name:               "PNGCompressionInvalid",
+			params:             imageserver.Params{"format": "png", "png_compression": "9"},
+			expectedParamError: "png_compression",
+		},
+	})
+}
+
+func TestBuildArgumentsDepth(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Depth",
+			params:            imageserver.Params{"format": "png", "depth": 8},
+			expectedArguments: []string{"-format", "png", "-depth", "8"},
+		},
+		{
+			name:              "DepthDefault",
+			handler:           &Handler{DefaultDepth: 8},
+			params:            imageserver.Params{"width": 100},
+			expectedArguments: []string{"-resize", "100x", "-depth", "8"},
+		},
+		{
+			name:              "DepthDefaultOverride",
+			handler:           &Handler{DefaultDepth: 8},
+			params:            imageserver.Params{"depth": 16},
+			expectedArguments: []string{"-depth", "16"},
+		},
+		{
+			name:               "DepthInvalidValue",
+			params:             imageserver.Params{"depth": 12},
+			expectedParamError: "depth",
+		},
+		{
+			name:               "DepthInvalid",
+			params:             imageserver.Params{"depth": "8"},
+			expectedParamError: "depth",
+		},
+	})
+}
+
+func TestBuildArgumentsInterlace(t *testing.T) {
+	testBuildArguments(t, []buildArgumentsTestCase{
+		{
+			name:              "Interlace",
+			params:            imageserver.Params{"format": "jpeg", "interlace": true},
+			expectedArguments: []string{"-format", "jpeg", "-interlace", "Line"},
+		},
+		{
+			name:              "InterlaceFalse",
+			params:            imageserver.Params{"width": 100, "interlace": false},
+			expectedArguments: []string{"-resize", "100x"},
+		},
+		{
+			name:              "InterlaceNotSet",
+			params:            imageserver.Params{"negate": true, "format": "png"},
+			expectedArguments: []string{"-negate", "-format", "png"},
+		},
+		{
+			name:              "InterlaceNone",
+			params:            imageserver.Params{"interlace": "None"},
+			expectedArguments: []string{"-interlace", "None"},
+		},
+		{
+			name:              "InterlaceLine",
+			params:            imageserver.Params{"interlace": "Line"},
+			expectedArguments: []string{"-interlace", "Line"},
+		},
+		{
+			name:              "InterlacePlane",
+			params:            imageserver.Params{"interlace": "Plane"},
+			expectedArguments: []string{"-interlace", "Plane"},
+		},
+		{
+			name:              "InterlacePartition",
+			params:            imageserver.Params{"interlace": "Partition"},
+			expectedArguments: []string{"-interlace", "Partition"},
+		},
+		{
+			name:              "InterlaceLowerCase",
+			params:            imageserver.Params{"interlace": "plane"},
+			expectedArguments: []string{"-interlace", "Plane"},
+		},
+		{
+			name:              "InterlaceDefault",
+			handler:           &Handler{DefaultInterlace: "line"},
+			params:            imageserver.Params{"format": "jpeg"},
+			expectedArguments: []string{"-format", "jpeg", "-interlace", "Line"},
+		},
+		{
+			name:              "InterlaceDefaultNoParams",
+			handler:           &Handler{DefaultInterlace: "line"},
+			params:            imageserver.Params{},
+			expectedArguments: []string{"-interlace", "Line"},
+		},
+		{
+			name:              "InterlaceDefaultOverride",
+			handler:           &Handler{DefaultInterlace: "Line"},
+			params:            imageserver.Params{"interlace": "partition"},
+			expectedArguments: []string{"-interlace", "Partition"},
+		},
+		{
+			name:    "InterlaceDefaultFalse",
+			handler: &Handler{DefaultInterlace: "Line"},
+			params:  imageserver.Params{"interlace": false},
+		},
+		{
+			name:               "InterlaceUnknown",
+			params:             imageserver.Params{"interlace": "foo"},
+			expectedParamError: "interlace",
+		},
+		{
+			name:               "InterlaceInvalid",
+			params:             imageserver.Params{"interlace": 1},
+			expectedParamError: "interlace",
+		},
+	})
+}
+
+func TestBuildArgumentsResizeMode(t *testing.T) {
+	for _, mode := range []string{"resize", "thumbnail", "sample", "scale"} {
+		for _, modifier := range []struct {
+			param  string
+			suffix string
+		}{
+			{param: "", suffix: ""},
+			{param: "fill", suffix: "^"},
+			{param: "ignore_ratio", suffix: "!"},
+			{param: "only_shrink_larger", suffix: ">"},
+			{param: "only_enlarge_smaller", suffix: "<"},
+		} {
+			t.Run(mode+modifier.suffix, func(t *testing.T) {
+				hdr := &Handler{}
+				params := imageserver.Params{"width": 100, "height": 200, "resize_mode": mode}
+				if modifier.param != "" {
+					params.Set(modifier.param, true)
+				}
+				arguments, _, _, err := hdr.buildArguments(testdata.Medium, params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := []string{"-" + mode, "100x200" + modifier.suffix}
+				argumentSlice := convertArgumentsToSlice(arguments)
+				if !reflect.DeepEqual(argumentSlice, expected) {
+					t.Fatalf("unexpected arguments: got %v, want %v", argumentSlice, expected)
+				}
+			})
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, tc := range []struct {
+		value         string
+		expected      string
+		expectedError bool
+	}{
+		{value: "10", expected: "10"},
+		{value: "10%", expected: "10%"},
+		{value: "10,1.5", expected: "10,1.5"},
+		{value: "5%,1.0,95%", expected: "5%,1,95%"},
+		{value: "0,0.1,255", expected: "0,0.1,255"},
+		{value: "10%,10,100%", expected: "10%,10,100%"},
+		{value: "", expectedError: true},
+		{value: "foo", expectedError: true},
+		{value: "-1", expectedError: true},
+		{value: "256", expectedError: true},
+		{value: "101%", expectedError: true},
+		{value: "10,0.05", expectedError: true},
+		{value: "10,11", expectedError: true},
+		{value: "10,foo,20", expectedError: true},
+		{value: "10,1,256", expectedError: true},
+		{value: "10,1,20,30", expectedError: true},
+		{value: "10%%", expectedError: true},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			res, err := parseLevel(tc.value)
+			if err != nil {
+				if _, ok := err.(*imageserver.ParamError); ok && tc.expectedError {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tc.expectedError {
+				t.Fatal("no error")
+			}
+			if res != tc.expected {
+				t.Fatalf("unexpected result: got %q, want %q", res, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGetGravity(t *testing.T) {
+	for _, tc := range []struct {
+		value         interface{}
+		expected      string
+		expectedError bool
+	}{
+		{value: nil, expected: "Center"},
+		{value: "", expected: "Center"},
+		{value: "nw", expected: "NorthWest"},
+		{value: "n", expected: "North"},
+		{value: "ne", expected: "NorthEast"},
+		{value: "w", expected: "West"},
+		{value: "c", expected: "Center"},
+		{value: "e", expected: "East"},
+		{value: "sw", expected: "SouthWest"},
+		{value: "s", expected: "South"},
+		{value: "se", expected: "SouthEast"},
+		{value: "NE", expected: "NorthEast"},
+		{value: "NorthWest", expected: "NorthWest"},
+		{value: "north", expected: "North"},
+		{value: "NorthEast", expected: "NorthEast"},
+		{value: "WEST", expected: "West"},
+		{value: "center", expected: "Center"},
+		{value: "Center", expected: "Center"},
+		{value: "east", expected: "East"},
+		{value: "southWest", expected: "SouthWest"},
+		{value: "South", expected: "South"},
+		{value: "southeast", expected: "SouthEast"},
+		{value: "foo", expectedError: true},
+		{value: "north-east", expectedError: true},
+		{value: 1, expectedError: true},
+	} {
+		t.Run(fmt.Sprint(tc.value), func(t *testing.T) {
+			params := imageserver.Params{}
+			if tc.value != nil {
+				params.Set("gravity", tc.value)
+			}
+			gravity, err := getGravity(params)
+			if err != nil {
+				if err, ok := err.(*imageserver.ParamError); ok && tc.expectedError && err.Param == "gravity" {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tc.expectedError {
+				t.Fatal("no error")
+			}
+			if gravity != tc.expected {
+				t.Fatalf("unexpected gravity: got %q, want %q", gravity, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBuildArgumentsFlattenAuto(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		image             *imageserver.Image
+		params            imageserver.Params
+		expectedArguments []string
+	}{
+		{
+			name:              "PNG",
+			image:             testdata.Rings,
+			params:            imageserver.Params{"format": "jpeg"},
+			expectedArguments: []string{"-background", "#ffffff", "-flatten", "-format", "jpeg"},
+		},
+		{
+			name:              "GIFBackground",
+			image:             testdata.Spaceship,
+			params:            imageserver.Params{"format": "jpeg", "background": "000"},
+			expectedArguments: []string{"-background", "#000", "-flatten", "-format", "jpeg"},
+		},
+		{
+			name:              "Disabled",
+			image:             testdata.Rings,
+			params:            imageserver.Params{"format": "jpeg", "flatten": false},
+			expectedArguments: []string{"-format", "jpeg"},
+		},
+		{
+			name:              "PNGOutput",
+			image:             testdata.Rings,
+			params:            imageserver.Params{"format": "png"},
+			expectedArguments: []string{"-format", "png"},
+		},
+		{
+			name:              "JPEGSource",
+			image:             testdata.Medium,
+			params:            imageserver.Params{"format": "jpeg"},
+			expectedArguments: []string{"-format", "jpeg"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hdr := &Handler{}
+			arguments, _, _, err := hdr.buildArguments(tc.image, tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			argumentSlice := convertArgumentsToSlice(arguments)
+			if !reflect.DeepEqual(argumentSlice, tc.expectedArguments) {
+				t.Fatalf("unexpected arguments: got %q, want %q", argumentSlice, tc.expectedArguments)
+			}
+		})
+	}
+}
+
+func TestBuildArgumentsCoalesce(t *testing.T) {
+	im := testNewAnimatedGIF(t)
+	for _, tc := range []struct {
+		name              string
+		backend           Backend
+		params            imageserver.Params
+		expectedArguments []string
+	}{
+		{
+			name:              "GraphicsMagick",
+			params:            imageserver.Params{"coalesce": true, "width": 2},
+			expectedArguments: []string{"-coalesce", "-resize", "2x", "-deconstruct"},
+		},
+		{
+			name:              "ImageMagick",
+			backend:           ImageMagick,
+			params:            imageserver.Params{"coalesce": true, "width": 2},
+			expectedArguments: []string{"-coalesce", "-resize", "2x", "-layers", "optimize"},
+		},
+		{
+			name:              "AutoOrient",
+			params:            imageserver.Params{"coalesce": true, "auto_orient": true, "width": 2},
+			expectedArguments: []string{"-auto-orient", "-coalesce", "-resize", "2x", "-deconstruct"},
+		},
+		{
+			name:              "FormatPNG",
+			params:            imageserver.Params{"coalesce": true, "width": 2, "format": "png"},
+			expectedArguments: []string{"-coalesce", "-resize", "2x", "-format", "png"},
+		},
+		{
+			name:              "FormatGIF",
+			params:            imageserver.Params{"coalesce": true, "width": 2, "format": "gif"},
+			expectedArguments: []string{"-coalesce", "-resize", "2x", "-format", "gif", "-deconstruct"},
+		},
+		{
+			name:              "False",
+			params:            imageserver.Params{"coalesce": false, "width": 2},
+			expectedArguments: []string{"-resize", "2x"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hdr := &Handler{Backend: tc.backend}
+			arguments, _, _, err := hdr.buildArguments(im, tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			argumentSlice := convertArgumentsToSlice(arguments)
+			if !reflect.DeepEqual(argumentSlice, tc.expectedArguments) {
+				t.Fatalf("unexpected arguments: got %q, want %q", argumentSlice, tc.expectedArguments)
+			}
+		})
+	}
+}
+
+func TestBuildArgumentsPNG8(t *testing.T) {
+	im := testNewTransparentPNG(t)
+	for _, tc := range []struct {
+		name                    string
+		params                  imageserver.Params
+		expectedArguments       []string
+		expectedCoder           string
+		expectedFormatSpecified bool
+	}{
+		{
+			name:          "NotSet",
+			params:        imageserver.Params{},
+			expectedCoder: "png",
+		},
+		{
+			name:                    "SourceFormat",
+			params:                  imageserver.Params{"png8": true},
+			expectedArguments:       []string{"-format", "png8"},
+			expectedCoder:           "png8",
+			expectedFormatSpecified: true,
+		},
+		{
+			name:                    "Format",
+			params:                  imageserver.Params{"format": "PNG", "png8": true},
+			expectedArguments:       []string{"-format", "png8"},
+			expectedCoder:           "png8",
+			expectedFormatSpecified: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hdr := &Handler{}
+			arguments, coder, formatSpecified, err := hdr.buildArguments(im, tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			argumentSlice := convertArgumentsToSlice(arguments)
+			if !reflect.DeepEqual(argumentSlice, tc.expectedArguments) && (len(argumentSlice) != 0 || len(tc.expectedArguments) != 0) {
+				t.Fatalf("unexpected arguments: got %q, want %q", argumentSlice, tc.expectedArguments)
+			}
+			if coder != tc.expectedCoder {
+				t.Fatalf("unexpected coder: got %q, want %q", coder, tc.expectedCoder)
+			}
+			if formatSpecified != tc.expectedFormatSpecified {
+				t.Fatalf("unexpected format specified: got %t, want %t", formatSpecified, tc.expectedFormatSpecified)
+			}
+		})
+	}
+}
+
+func TestHandlePNG8FakeExecutable(t *testing.T) {
+	for _, ext := range []string{"png8", "png"} {
+		t.Run(ext, func(t *testing.T) {
+			// Simulates "mogrify -format png8", which writes the output to "<file>.png8" or "<file>.png".
+			executable, cleanup := testFakeExecutable(t, `for f; do :; done; cp "$f" "$f.`+ext+`"`)
+			defer cleanup()
+			hdr := &Handler{
+				Executable: executable,
+			}
+			im, err := hdr.Handle(testNewTransparentPNG(t), imageserver.Params{param: imageserver.Params{"png8": true}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if im.Format != "png" {
+				t.Fatalf("unexpected format: got %s, want %s", im.Format, "png")
+			}
+		})
+	}
+}
+
+func TestHandlePNG8(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width":  100,
+			"format": "png",
+			"png8":   true,
+		},
+	}
+	im, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if im.Format != "png" {
+		t.Fatalf("unexpected format: got %s, want %s", im.Format, "png")
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(im.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.ColorModel.(color.Palette); !ok {
+		t.Fatalf("unexpected color model: %T", cfg.ColorModel)
+	}
+}
+
+func TestBuildArgumentsCoalesceErrorInvalid(t *testing.T) {
+	hdr := &Handler{}
+	_, _, _, err := hdr.buildArguments(testNewAnimatedGIF(t), imageserver.Params{"coalesce": "true"})
+	if err, ok := err.(*imageserver.ParamError); !ok || err.Param != "coalesce" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// testNewAnimatedGIF returns a tiny animated GIF Image with 2 frames.
+func testNewAnimatedGIF(tb testing.TB) *imageserver.Image {
+	g := &gif.GIF{}
+	for _, c := range []color.Color{color.Black, color.White} {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := new(bytes.Buffer)
+	err := gif.EncodeAll(buf, g)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &imageserver.Image{Format: "gif", Data: buf.Bytes()}
+}
+
+func TestGetCropRatioSize(t *testing.T) {
+	for _, tc := range []struct {
+		width, height, ratioWidth, ratioHeight int
+		expectedWidth, expectedHeight          int
+	}{
+		{width: 1024, height: 819, ratioWidth: 16, ratioHeight: 9, expectedWidth: 1024, expectedHeight: 576},
+		{width: 1024, height: 819, ratioWidth: 1, ratioHeight: 1, expectedWidth: 819, expectedHeight: 819},
+		{width: 100, height: 100, ratioWidth: 3, ratioHeight: 2, expectedWidth: 100, expectedHeight: 67},
+		{width: 100, height: 100, ratioWidth: 2, ratioHeight: 3, expectedWidth: 67, expectedHeight: 100},
+		{width: 100, height: 1, ratioWidth: 1, ratioHeight: 1000, expectedWidth: 1, expectedHeight: 1},
+	} {
+		width, height := getCropRatioSize(tc.width, tc.height, tc.ratioWidth, tc.ratioHeight)
+		if width != tc.expectedWidth || height != tc.expectedHeight {
+			t.Fatalf("unexpected size for %dx%d %d:%d: got %dx%d, want %dx%d", tc.width, tc.height, tc.ratioWidth, tc.ratioHeight, width, height, tc.expectedWidth, tc.expectedHeight)
+		}
+	}
+}
+
+func TestHandleParamErrorCrop(t *testing.T) {
+	hdr := &Handler{}
+	_, err := hdr.Handle(testdata.Medium, imageserver.Params{param: imageserver.Params{"crop": "200,abc,10,20"}})
+	if err, ok := err.(*imageserver.ParamError); !ok || err.Param != param+".crop" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBuildArgumentsCropErrorDecode(t *testing.T) {
+	hdr := &Handler{}
+	_, _, _, err := hdr.buildArguments(testdata.Invalid, imageserver.Params{"crop": "50%,50%,0,0"})
+	if _, ok := err.(*imageserver.ImageError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _, _, err = hdr.buildArguments(testdata.Invalid, imageserver.Params{"crop": "50,50,0,0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildArgumentsExtentErrorDecode(t *testing.T) {
+	hdr := &Handler{}
+	_, _, _, err := hdr.buildArguments(testdata.Invalid, imageserver.Params{"width": 100, "extent": true})
+	if _, ok := err.(*imageserver.ImageError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBuildArgumentsMaxSizeErrorDecode(t *testing.T) {
+	hdr := &Handler{MaxWidth: 1000, MaxPixels: 1000000}
+	for _, tc := range []struct {
+		name   string
+		params imageserver.Params
+	}{
+		{
+			name:   "Width",
+			params: imageserver.Params{"width": 1000},
+		},
+		{
+			name:   "WidthHeight",
+			params: imageserver.Params{"width": 1000, "height": 1000},
+		},
+		{
+			name:   "ResizePercent",
+			params: imageserver.Params{"resize_percent": 50.0},
+		},
+		{
+			name:   "ResizeArea",
+			params: imageserver.Params{"resize_area": 10000},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, _, err := hdr.buildArguments(testdata.Invalid, tc.params)
+			if _, ok := err.(*imageserver.ImageError); !ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetResizeSize(t *testing.T) {
+	for _, tc := range []struct {
+		geometry       string
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{geometry: "100x", expectedWidth: 100, expectedHeight: 80},
+		{geometry: "x100", expectedWidth: 125, expectedHeight: 100},
+		{geometry: "100x100", expectedWidth: 100, expectedHeight: 80},
+		{geometry: "100x100^", expectedWidth: 125, expectedHeight: 100},
+		{geometry: "100x100!", expectedWidth: 100, expectedHeight: 100},
+		{geometry: "2048x>", expectedWidth: 1024, expectedHeight: 819},
+		{geometry: "512x>", expectedWidth: 512, expectedHeight: 410},
+		{geometry: "512x<", expectedWidth: 1024, expectedHeight: 819},
+		{geometry: "2048x<", expectedWidth: 2048, expectedHeight: 1638},
+		{geometry: "50%", expectedWidth: 512, expectedHeight: 410},
+		{geometry: "209664@", expectedWidth: 512, expectedHeight: 410},
+	} {
+		width, height := getResizeSize(tc.geometry, 1024, 819)
+		if width != tc.expectedWidth || height != tc.expectedHeight {
+			t.Fatalf("unexpected size for %q: got %dx%d, want %dx%d", tc.geometry, width, height, tc.expectedWidth, tc.expectedHeight)
+		}
+	}
+}
+
+func TestBuildArgumentsRotateErrorDecode(t *testing.T) {
+	hdr := &Handler{}
+	_, _, _, err := hdr.buildArguments(testdata.Invalid, imageserver.Params{"rotate": 30.0, "rotate_expand": false})
+	if _, ok := err.(*imageserver.ImageError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBuildArgumentsCropRatioErrorDecode(t *testing.T) {
+	hdr := &Handler{}
+	_, _, _, err := hdr.buildArguments(testdata.Invalid, imageserver.Params{"crop_ratio": "1:1"})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if _, ok := err.(*imageserver.ImageError); !ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+}
+
+func TestPNGCompressionQuality(t *testing.T) {
+	for level, expected := range []int{5, 15, 25, 35, 45, 55, 65, 75, 85, 95} {
+		quality := pngCompressionQuality(level)
+		if quality != expected {
+			t.Fatalf("unexpected quality for level %d: got %d, want %d", level, quality, expected)
+		}
+	}
+}
+
+func TestBuildArgumentsDefine(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		format        string
+		defineName    string
+		value         string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:       "OK",
+			format:     "webp",
+			defineName: "alpha-quality",
+			value:      "50",
+			expected:   "webp:alpha-quality=50",
+		},
+		{
+			name:          "EmptyValue",
+			format:        "webp",
+			defineName:    "lossless",
+			expectedError: true,
+		},
+		{
+			name:          "Separator",
+			format:        "webp",
+			defineName:    "lossless",
+			value:         "true,png:foo=bar",
+			expectedError: true,
+		},
+		{
+			name:          "Space",
+			format:        "jpeg",
+			defineName:    "extent",
+			value:         "1 KB",
+			expectedError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			arguments := list.New()
+			err := buildArgumentsDefine(arguments, tc.format, tc.defineName, tc.value)
+			if tc.expectedError {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				if arguments.Len() != 0 {
+					t.Fatalf("unexpected arguments: %v", convertArgumentsToSlice(arguments))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := convertArgumentsToSlice(arguments)
+			if len(args) != 2 || args[0] != "-define" || args[1] != tc.expected {
+				t.Fatalf("unexpected arguments: got %v, want %v", args, []string{"-define", tc.expected})
+			}
+		})
+	}
+}
+
+func TestBuildArgumentsErrorDefaultSamplingFactor(t *testing.T) {
+	hdr := &Handler{DefaultSamplingFactor: "2x2"}
+	_, _, _, err := hdr.buildArguments(testdata.Medium, imageserver.Params{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if _, ok := err.(*imageserver.ParamError); ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+}
+
+func TestBuildArgumentsErrorDefaultInterlace(t *testing.T) {
+	hdr := &Handler{DefaultInterlace: "foo"}
+	_, _, _, err := hdr.buildArguments(testdata.Medium, imageserver.Params{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if _, ok := err.(*imageserver.ParamError); ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+}
+
+func TestNormalizeAngle(t *testing.T) {
+	for _, tc := range []struct {
+		angle    float64
+		expected float64
+	}{
+		{angle: 0, expected: 0},
+		{angle: 90, expected: 90},
+		{angle: -90, expected: 270},
+		{angle: 450, expected: 90},
+		{angle: 360, expected: 0},
+		{angle: -360, expected: 0},
+		{angle: 12.5, expected: 12.5},
+		{angle: -12.5, expected: 347.5},
+	} {
+		result := normalizeAngle(tc.angle)
+		if result != tc.expected {
+			t.Fatalf("unexpected result for %v: got %v, want %v", tc.angle, result, tc.expected)
+		}
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	for _, tc := range []struct {
+		value         string
+		expectedX     int
+		expectedY     int
+		expectedError bool
+	}{
+		{value: "+10+20", expectedX: 10, expectedY: 20},
+		{value: "+10-20", expectedX: 10, expectedY: -20},
+		{value: "-10+20", expectedX: -10, expectedY: 20},
+		{value: "-10-20", expectedX: -10, expectedY: -20},
+		{value: "10,20", expectedX: 10, expectedY: 20},
+		{value: "-10,-20", expectedX: -10, expectedY: -20},
+		{value: "+0+0", expectedX: 0, expectedY: 0},
+		{value: "", expectedError: true},
+		{value: "10", expectedError: true},
+		{value: "+10", expectedError: true},
+		{value: "10+20", expectedError: true},
+		{value: "+1.5+2", expectedError: true},
+		{value: "1,2.5", expectedError: true},
+		{value: "1,2,3", expectedError: true},
+		{value: "a,b", expectedError: true},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			x, y, err := parseOffset("offset", tc.value)
+			if err != nil {
+				if err, ok := err.(*imageserver.ParamError); ok && tc.expectedError && err.Param == "offset" {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tc.expectedError {
+				t.Fatal("no error")
+			}
+			if x != tc.expectedX || y != tc.expectedY {
+				t.Fatalf("unexpected offset: got %d,%d, want %d,%d", x, y, tc.expectedX, tc.expectedY)
+			}
+		})
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	for _, tc := range []struct {
+		x        int
+		y        int
+		expected string
+	}{
+		{x: 10, y: 20, expected: "+10+20"},
+		{x: 10, y: -20, expected: "+10-20"},
+		{x: -10, y: 20, expected: "-10+20"},
+		{x: -10, y: -20, expected: "-10-20"},
+		{x: 0, y: 0, expected: "+0+0"},
+	} {
+		t.Run(tc.expected, func(t *testing.T) {
+			offset := formatOffset(tc.x, tc.y)
+			if offset != tc.expected {
+				t.Fatalf("unexpected offset: got %q, want %q", offset, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHandleParamError(t *testing.T) {
+	hdr := &Handler{}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"blur": "foo",
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if err, ok := err.(*imageserver.ParamError); !ok || err.Param != param+".blur" {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+}
+
+func TestHandleErrorStderr(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	arguments := list.New()
+	arguments.PushBack("-invalid-argument")
+	_, err := hdr.process(context.Background(), testdata.Medium, arguments, nil, nil, testdata.Medium.Format, false)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if _, ok := err.(*imageserver.ImageError); !ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+	if !strings.Contains(err.Error(), "-invalid-argument") {
+		t.Fatalf("error doesn't contain the GraphicsMagick diagnostic: %s", err)
+	}
+}
+
+func TestHandleErrorStderrFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, "echo 'gm mogrify: invalid argument' >&2\nexit 1")
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if _, ok := err.(*imageserver.ImageError); !ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+	if !strings.HasSuffix(err.Error(), "exit status 1: gm mogrify: invalid argument") {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+}
+
+func TestHandleContextCancel(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, "exec sleep 10")
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := hdr.HandleContext(ctx, testdata.Medium, params)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if _, ok := err.(*imageserver.ImageError); !ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+	if !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("process not killed: elapsed %s", elapsed)
+	}
+}
+
+func TestRunCommandWaitContextDoneSuccess(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, "exit 0")
+	defer cleanup()
+	hdr := &Handler{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := hdr.runCommandWait(ctx, exec.Command(executable))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleTimeoutFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, "exec sleep 10")
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+		Timeout:    100 * time.Millisecond,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if !strings.Contains(err.Error(), "timeout after 100ms") {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+}
+
+func TestHandleMaxConcurrent(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `lock="$(dirname "$0")/lock"
+mkdir "$lock" || { echo "concurrent process" >&2; exit 1; }
+sleep 0.2
+rmdir "$lock"`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable:    executable,
+		MaxConcurrent: 1,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+		},
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := hdr.Handle(testdata.Medium, params)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHandleMaxConcurrentContext(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, "exec sleep 10")
+	defer cleanup()
+	hdr := &Handler{
+		Executable:    executable,
+		MaxConcurrent: 1,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := hdr.HandleContext(ctx, testdata.Medium, params)
+		errs <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer waitCancel()
+	_, err := hdr.HandleContext(waitCtx, testdata.Medium, params)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if !strings.Contains(err.Error(), "concurrency limit") {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+	cancel()
+	<-errs
+}
+
+func TestHandleResourceLimitsHugeImage(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+		ResourceLimits: ResourceLimits{
+			Pixels: "1000000",
+		},
+	}
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 5000, 5000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	im := &imageserver.Image{Format: "png", Data: buf.Bytes()}
+	_, err = hdr.Handle(im, imageserver.Params{param: imageserver.Params{"width": 100}})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if _, ok := err.(*imageserver.ImageError); !ok {
+		t.Fatalf("unexpected error type: %T", err)
+	}
+}
+
+func TestHandleResourceLimitsInvalidFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `echo "$@" >> "$(dirname "$0")/log"`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+		ResourceLimits: ResourceLimits{
+			Memory: "lots",
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, imageserver.Params{param: imageserver.Params{"width": 100}})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(executable), "log"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("the command was executed: %v", err)
+	}
+}
+
+func TestBuildResourceLimitArguments(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		backend           Backend
+		resourceLimits    ResourceLimits
+		expectedArguments []string
+		expectedError     bool
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name: "Sorted",
+			resourceLimits: ResourceLimits{
+				Pixels: "10000000",
+				Map:    "128MB",
+			},
+			expectedArguments: []string{"-limit", "map", "128MB", "-limit", "pixels", "10000000"},
+		},
+		{
+			name: "Others",
+			resourceLimits: ResourceLimits{
+				Memory: "256MB",
+				Others: map[string]string{
+					"width":  "10000",
+					"height": "10000",
+				},
+			},
+			expectedArguments: []string{"-limit", "height", "10000", "-limit", "memory", "256MB", "-limit", "width", "10000"},
+		},
+		{
+			name:           "OthersUnknown",
+			resourceLimits: ResourceLimits{Others: map[string]string{"area": "128MB"}},
+			expectedError:  true,
+		},
+		{
+			name:           "OthersTyped",
+			resourceLimits: ResourceLimits{Others: map[string]string{"memory": "128MB"}},
+			expectedError:  true,
+		},
+		{
+			name:    "ImageMagick",
+			backend: ImageMagick,
+			resourceLimits: ResourceLimits{
+				Pixels:  "10000000",
+				Threads: "4",
+			},
+			expectedArguments: []string{"-limit", "area", "10000000", "-limit", "thread", "4"},
+		},
+		{
+			name:              "ImageMagickOthers",
+			backend:           ImageMagick,
+			resourceLimits:    ResourceLimits{Others: map[string]string{"list-length": "100"}},
+			expectedArguments: []string{"-limit", "list-length", "100"},
+		},
+		{
+			name:           "ImageMagickOthersUnknown",
+			backend:        ImageMagick,
+			resourceLimits: ResourceLimits{Others: map[string]string{"read": "128MB"}},
+			expectedError:  true,
+		},
+		{
+			name:           "OthersEmptyValue",
+			resourceLimits: ResourceLimits{Others: map[string]string{"file": ""}},
+			expectedError:  true,
+		},
+		{
+			name: "Units",
+			resourceLimits: ResourceLimits{
+				Memory:  "256MB",
+				Map:     "1GiB",
+				Disk:    "2G",
+				Threads: "4",
+			},
+			expectedArguments: []string{"-limit", "disk", "2G", "-limit", "map", "1GiB", "-limit", "memory", "256MB", "-limit", "threads", "4"},
+		},
+		{
+			name:           "InvalidValue",
+			resourceLimits: ResourceLimits{Memory: "lots"},
+			expectedError:  true,
+		},
+		{
+			name:           "InvalidUnit",
+			resourceLimits: ResourceLimits{Memory: "256XB"},
+			expectedError:  true,
+		},
+		{
+			name:           "NegativeValue",
+			resourceLimits: ResourceLimits{Memory: "-1MB"},
+			expectedError:  true,
+		},
+		{
+			name:           "UnitOnly",
+			resourceLimits: ResourceLimits{Memory: "MB"},
+			expectedError:  true,
+		},
+		{
+			name:           "OthersInvalidValue",
+			resourceLimits: ResourceLimits{Others: map[string]string{"file": "lots"}},
+			expectedError:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hdr := &Handler{
+				Backend:        tc.backend,
+				ResourceLimits: tc.resourceLimits,
+			}
+			arguments, err := hdr.buildResourceLimitArguments()
+			if err != nil {
+				if tc.expectedError {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tc.expectedError {
+				t.Fatal("no error")
+			}
+			if !reflect.DeepEqual(arguments, tc.expectedArguments) {
+				t.Fatalf("unexpected arguments: got %v, want %v", arguments, tc.expectedArguments)
+			}
+		})
+	}
+}
+
+func TestHandleDetectFormatFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, fmt.Sprintf(`for f; do :; done
+cp %q "$f"`, filepath.Join(testdata.Dir, testdata.RingsFileName)))
+	defer cleanup()
+	logBuf := new(bytes.Buffer)
+	hdr := &Handler{
+		Executable: executable,
+		Logger:     log.New(logBuf, "", 0),
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+		},
+	}
+	im, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if im.Format != "png" {
+		t.Fatalf("unexpected format: got %s, want %s", im.Format, "png")
+	}
+	expectedLog := "GraphicsMagick output format mismatch: expected jpeg, detected png\n"
+	if logBuf.String() != expectedLog {
+		t.Fatalf("unexpected log: got %q, want %q", logBuf.String(), expectedLog)
+	}
+}
+
+func TestHandleCommandFuncFakeExecutable(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		script        string
+		expectedError bool
+	}{
+		{
+			name: "Success",
+		},
+		{
+			name:          "Error",
+			script:        "exit 1",
+			expectedError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			executable, cleanup := testFakeExecutable(t, "sleep 0.01\n"+tc.script)
+			defer cleanup()
+			var durations []time.Duration
+			var errs []error
+			hdr := &Handler{
+				Executable: executable,
+				CommandFunc: func(duration time.Duration, err error) {
+					durations = append(durations, duration)
+					errs = append(errs, err)
+				},
+			}
+			params := imageserver.Params{
+				param: imageserver.Params{
+					"width": 100,
+				},
+			}
+			_, err := hdr.Handle(testdata.Medium, params)
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(durations) != 1 {
+				t.Fatalf("unexpected calls count: got %d, want 1", len(durations))
+			}
+			if durations[0] < 10*time.Millisecond {
+				t.Fatalf("unexpected duration: %s", durations[0])
+			}
+			if (errs[0] != nil) != tc.expectedError {
+				t.Fatalf("unexpected observed error: %v", errs[0])
+			}
+			if tc.expectedError && errs[0] != err {
+				t.Fatalf("unexpected observed error: got %v, want %v", errs[0], err)
+			}
+		})
+	}
+}
+
+func TestHandleServerOptionsNoParamsFakeExecutable(t *testing.T) {
+	// Records that the command was executed, and simulates "-strip", by replacing the Image with one without EXIF.
+	executable, cleanup := testFakeExecutable(t, fmt.Sprintf(`touch "$(dirname "$0")/executed"
+for f; do :; done
+case " $* " in *" -strip "*) cp %q "$f";; esac`, filepath.Join(testdata.Dir, testdata.SmallFileName)))
+	defer cleanup()
+	for _, tc := range []struct {
+		name             string
+		handler          *Handler
+		params           imageserver.Params
+		expectedExecuted bool
+	}{
+		{
+			name:             "AlwaysStripMissing",
+			handler:          &Handler{AlwaysStrip: true},
+			params:           imageserver.Params{},
+			expectedExecuted: true,
+		},
+		{
+			name:             "AlwaysStripEmpty",
+			handler:          &Handler{AlwaysStrip: true},
+			params:           imageserver.Params{param: imageserver.Params{}},
+			expectedExecuted: true,
+		},
+		{
+			name:             "AutoOrientDefault",
+			handler:          &Handler{AutoOrientDefault: true},
+			params:           imageserver.Params{},
+			expectedExecuted: true,
+		},
+		{
+			name:             "DefaultInterlace",
+			handler:          &Handler{DefaultInterlace: "line"},
+			params:           imageserver.Params{},
+			expectedExecuted: true,
+		},
+		{
+			name:             "ConvertToSRGB",
+			handler:          &Handler{ConvertToSRGB: true},
+			params:           imageserver.Params{},
+			expectedExecuted: true,
+		},
+		{
+			name:    "NoOptions",
+			handler: &Handler{},
+			params:  imageserver.Params{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			executedFile := filepath.Join(filepath.Dir(executable), "executed")
+			_ = os.Remove(executedFile)
+			tc.handler.Executable = executable
+			im, err := tc.handler.Handle(testdata.Medium, tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = os.Stat(executedFile)
+			if !tc.expectedExecuted {
+				if !os.IsNotExist(err) {
+					t.Fatalf("the command was executed: %v", err)
+				}
+				if im != testdata.Medium {
+					t.Fatal("the Image was modified")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("the command was not executed: %v", err)
+			}
+			if tc.handler.AlwaysStrip && bytes.Contains(im.Data, []byte("Exif\x00\x00")) {
+				t.Fatal("EXIF marker found")
+			}
+		})
+	}
+}
+
+func TestHandleKeepTempFilesFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, "")
+	defer cleanup()
+	for _, keep := range []bool{true, false} {
+		t.Run(strconv.FormatBool(keep), func(t *testing.T) {
+			var tempDir string
+			hdr := &Handler{
+				Executable:    executable,
+				TempDir:       filepath.Dir(executable),
+				KeepTempFiles: keep,
+				TempDirFunc: func(dir string) {
+					tempDir = dir
+				},
+			}
+			params := imageserver.Params{
+				param: imageserver.Params{
+					"width": 100,
+				},
+			}
+			_, err := hdr.Handle(testdata.Medium, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Dir(tempDir) != hdr.TempDir {
+				t.Fatalf("unexpected temp dir: %q", tempDir)
+			}
+			_, err = os.Stat(filepath.Join(tempDir, "image"))
+			if keep && err != nil {
+				t.Fatal(err)
+			}
+			if !keep && !os.IsNotExist(err) {
+				t.Fatalf("temp file not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleFormatAliasFakeExecutable(t *testing.T) {
+	// Simulates "mogrify -format", which writes the output to "<file>.<format>".
+	executable, cleanup := testFakeExecutable(t, `for f; do :; done; cp "$f" "$f.jpeg"`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable:     executable,
+		AllowedFormats: []string{"jpeg"},
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"format": "JPG",
+		},
+	}
+	im, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if im.Format != "jpeg" {
+		t.Fatalf("unexpected format: got %s, want %s", im.Format, "jpeg")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		data             []byte
+		expected         string
+		expectedDetected string
+		expectedMismatch bool
+	}{
+		{
+			name:             "JPEG",
+			data:             testdata.Medium.Data,
+			expected:         "jpeg",
+			expectedDetected: "jpeg",
+		},
+		{
+			name:             "PNG",
+			data:             testdata.Rings.Data,
+			expected:         "png",
+			expectedDetected: "png",
+		},
+		{
+			name:             "Mismatch",
+			data:             testdata.Rings.Data,
+			expected:         "jpeg",
+			expectedDetected: "png",
+			expectedMismatch: true,
+		},
+		{
+			name:             "GIF",
+			data:             testdata.Animated.Data,
+			expected:         "gif",
+			expectedDetected: "gif",
+		},
+		{
+			name:             "WebP",
+			data:             []byte("RIFF\x24\x00\x00\x00WEBPVP8 "),
+			expected:         "webp",
+			expectedDetected: "webp",
+		},
+		{
+			name:             "TIFFLittleEndian",
+			data:             []byte("II*\x00\x08\x00\x00\x00"),
+			expected:         "tiff",
+			expectedDetected: "tiff",
+		},
+		{
+			name:             "TIFFBigEndian",
+			data:             []byte("MM\x00*\x00\x00\x00\x08"),
+			expected:         "jpeg",
+			expectedDetected: "tiff",
+			expectedMismatch: true,
+		},
+		{
+			name:             "BMP",
+			data:             []byte("BM\x3e\x00\x00\x00"),
+			expected:         "bmp",
+			expectedDetected: "bmp",
+		},
+		{
+			name:             "Unknown",
+			data:             []byte("foo"),
+			expected:         "svg",
+			expectedDetected: "svg",
+		},
+		{
+			name:             "Empty",
+			data:             nil,
+			expected:         "png",
+			expectedDetected: "png",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logBuf := new(bytes.Buffer)
+			hdr := &Handler{
+				Logger: log.New(logBuf, "", 0),
+			}
+			detected := hdr.detectFormat(tc.data, tc.expected)
+			if detected != tc.expectedDetected {
+				t.Fatalf("unexpected format: got %s, want %s", detected, tc.expectedDetected)
+			}
+			if mismatch := logBuf.Len() > 0; mismatch != tc.expectedMismatch {
+				t.Fatalf("unexpected mismatch: got %t, want %t", mismatch, tc.expectedMismatch)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	err := hdr.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Version() == "" {
+		t.Fatal("empty version")
+	}
+}
+
+func TestValidateFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `echo "GraphicsMagick 1.3.35 2020-02-23 Q16 http://www.GraphicsMagick.org/"
+echo "Copyright (C) 2002-2020 GraphicsMagick Group."`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+	}
+	err := hdr.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Version() != "1.3.35" {
+		t.Fatalf("unexpected version: got %q, want %q", hdr.Version(), "1.3.35")
+	}
+}
+
+func TestValidateErrorNotGraphicsMagick(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `echo "Version: ImageMagick 6.9.10-23 Q16 x86_64 20190101 https://imagemagick.org"`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+	}
+	err := hdr.Validate()
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if !strings.Contains(err.Error(), "not GraphicsMagick") {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+	if hdr.Version() != "" {
+		t.Fatalf("unexpected version: %q", hdr.Version())
+	}
+}
+
+func TestValidateErrorNotFound(t *testing.T) {
+	hdr := &Handler{
+		Executable: filepath.Join(testdata.Dir, "nonexistent", "gm"),
+	}
+	err := hdr.Validate()
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if !strings.Contains(err.Error(), hdr.Executable) {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		handler           *Handler
+		arguments         []string
+		expectedArguments []string
+	}{
+		{
+			name:              "GraphicsMagickMogrify",
+			handler:           &Handler{},
+			arguments:         []string{"mogrify", "-resize", "100x", "file"},
+			expectedArguments: []string{"mogrify", "-resize", "100x", "file"},
+		},
+		{
+			name:              "GraphicsMagickMogrifyResourceLimits",
+			handler:           &Handler{ResourceLimits: ResourceLimits{Memory: "64MB", Disk: "0"}},
+			arguments:         []string{"mogrify", "-resize", "100x", "file"},
+			expectedArguments: []string{"mogrify", "-limit", "disk", "0", "-limit", "memory", "64MB", "-resize", "100x", "file"},
+		},
+		{
+			name:              "GraphicsMagickConvert",
+			handler:           &Handler{ResourceLimits: ResourceLimits{Memory: "64MB"}},
+			arguments:         []string{"convert", "-", "-resize", "100x", "jpeg:-"},
+			expectedArguments: []string{"convert", "-limit", "memory", "64MB", "-", "-resize", "100x", "jpeg:-"},
+		},
+		{
+			name:              "ImageMagickMogrify",
+			handler:           &Handler{Backend: ImageMagick},
+			arguments:         []string{"mogrify", "-resize", "100x", "file"},
+			expectedArguments: []string{"mogrify", "-resize", "100x", "file"},
+		},
+		{
+			name:              "ImageMagickConvert",
+			handler:           &Handler{Backend: ImageMagick, ResourceLimits: ResourceLimits{Pixels: "128MB"}},
+			arguments:         []string{"convert", "-", "-resize", "100x", "jpeg:-"},
+			expectedArguments: []string{"-limit", "area", "128MB", "-", "-resize", "100x", "jpeg:-"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.handler.Executable = "executable"
+			cmd, err := tc.handler.command(context.Background(), tc.arguments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cmd.Args[1:], tc.expectedArguments) {
+				t.Fatalf("unexpected arguments: got %v, want %v", cmd.Args[1:], tc.expectedArguments)
+			}
+		})
+	}
+}
+
+func TestHandleDensityUseStdioFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `echo "$@" >> "$(dirname "$0")/log"
+cat`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+		UseStdio:   true,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width":   100,
+			"density": "300",
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(executable), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "convert -density 300 - -resize 100x jpeg:-\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected command: got %q, want %q", data, expected)
+	}
+}
+
+func TestHandleFormatUseStdioFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `echo "$@" >> "$(dirname "$0")/log"
+cat`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+		UseStdio:   true,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width":  100,
+			"format": "png",
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(executable), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "convert - -resize 100x png:-\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected command: got %q, want %q", data, expected)
+	}
+}
+
+func TestHandleImageMagickFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `echo "$@" >> "$(dirname "$0")/log"
+cat`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+		Backend:    ImageMagick,
+		UseStdio:   true,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+		},
+	}
+	im, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(im.Data, testdata.Medium.Data) {
+		t.Fatal("unexpected data")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(executable), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "- -resize 100x jpeg:-\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected command: got %q, want %q", data, expected)
+	}
+}
+
+func TestValidateImageMagickFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `[ "$1" = "-version" ] || exit 1
+echo "Version: ImageMagick 7.0.10-0 Q16 x86_64 2020-03-14 https://imagemagick.org"`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+		Backend:    ImageMagick,
+	}
+	err := hdr.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Version() != "7.0.10-0" {
+		t.Fatalf("unexpected version: got %q, want %q", hdr.Version(), "7.0.10-0")
+	}
+}
+
+func TestHandleWatermarkFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `echo "$1" >> "$(dirname "$0")/log"`)
+	defer cleanup()
+	var source interface{}
+	hdr := &Handler{
+		Executable: executable,
+		WatermarkServer: imageserver.ServerFunc(func(params imageserver.Params) (*imageserver.Image, error) {
+			source, _ = params.Get("source")
+			return testdata.Small, nil
+		}),
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width": 100,
+			"watermark": imageserver.Params{
+				"source":   "logo.png",
+				"gravity":  "se",
+				"dissolve": 50,
+				"x":        10,
+				"y":        10,
+			},
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "logo.png" {
+		t.Fatalf("unexpected source: %v", source)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(executable), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "mogrify\ncomposite\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected commands: got %q, want %q", data, expected)
+	}
+}
+
+func TestHandleWatermarkErrorServer(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, "exit 0")
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+		WatermarkServer: imageserver.ServerFunc(func(params imageserver.Params) (*imageserver.Image, error) {
+			return nil, &imageserver.ParamError{Param: "source", Message: "not found"}
+		}),
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"watermark": imageserver.Params{
+				"source": "unknown.png",
+			},
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err, ok := err.(*imageserver.ParamError); !ok || err.Param != param+".watermark.source" {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+}
+
+func TestHandleVignetteFakeExecutable(t *testing.T) {
+	executable, cleanup := testFakeExecutable(t, `echo "$1" >> "$(dirname "$0")/log"`)
+	defer cleanup()
+	hdr := &Handler{
+		Executable: executable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"vignette": true,
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(executable), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "mogrify\nconvert\ncomposite\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected commands: got %q, want %q", data, expected)
+	}
+}
+
+func TestHandleVignette(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"width":    100,
+			"vignette": true,
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildStepSRGB(t *testing.T) {
+	profile := []byte("icc")
+	for _, tc := range []struct {
+		name               string
+		handler            *Handler
+		params             imageserver.Params
+		expectedStep       bool
+		expectedParamError string
+	}{
+		{
+			name:    "Empty",
+			handler: &Handler{SRGBProfile: profile},
+			params:  imageserver.Params{},
+		},
+		{
+			name:         "SRGB",
+			handler:      &Handler{SRGBProfile: profile},
+			params:       imageserver.Params{"srgb": true},
+			expectedStep: true,
+		},
+		{
+			name:    "SRGBFalse",
+			handler: &Handler{SRGBProfile: profile},
+			params:  imageserver.Params{"srgb": false},
+		},
+		{
+			name:         "Default",
+			handler:      &Handler{SRGBProfile: profile, ConvertToSRGB: true},
+			params:       imageserver.Params{},
+			expectedStep: true,
+		},
+		{
+			name:    "DefaultSRGBFalse",
+			handler: &Handler{SRGBProfile: profile, ConvertToSRGB: true},
+			params:  imageserver.Params{"srgb": false},
+		},
+		{
+			name:         "BundledProfile",
+			handler:      &Handler{},
+			params:       imageserver.Params{"srgb": true},
+			expectedStep: true,
+		},
+		{
+			name:               "Invalid",
+			handler:            &Handler{SRGBProfile: profile},
+			params:             imageserver.Params{"srgb": "true"},
+			expectedParamError: "srgb",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := tc.handler.buildStepSRGB(tc.params)
+			if err != nil {
+				if err, ok := err.(*imageserver.ParamError); ok && tc.expectedParamError == err.Param {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tc.expectedParamError != "" {
+				t.Fatal("no error")
+			}
+			if (st != nil) != tc.expectedStep {
+				t.Fatalf("unexpected step: got %t, want %t", st != nil, tc.expectedStep)
+			}
+		})
+	}
+}
+
+func TestBuildSRGBArguments(t *testing.T) {
+	arguments := buildSRGBArguments("srgb.icc", "image")
+	expected := []string{"mogrify", "-profile", "srgb.icc", "+profile", "icc", "image"}
+	if !reflect.DeepEqual(arguments, expected) {
+		t.Fatalf("unexpected arguments: got %q, want %q", arguments, expected)
+	}
+}
+
+func TestHandleSRGBFakeExecutable(t *testing.T) {
+	// Copies the profile given to "-profile".
+	executable, cleanup := testFakeExecutable(t, `if [ "$2" = "-profile" ]; then cp "$3" "$(dirname "$0")/profile"; fi`)
+	defer cleanup()
+	dir := filepath.Dir(executable)
+	hdr := &Handler{
+		Executable: executable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"srgb":  true,
+			"width": 100,
+		},
+	}
+	_, err := hdr.Handle(testdata.Medium, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := ioutil.ReadFile(filepath.Join(dir, "profile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(profile, srgbProfile) {
+		t.Fatal("the bundled sRGB profile is not used")
+	}
+}
+
+func TestHandleSRGB(t *testing.T) {
+	testCheckAvailable(t)
+	hdr := &Handler{
+		Executable: testExecutable,
+	}
+	params := imageserver.Params{
+		param: imageserver.Params{
+			"srgb":   true,
+			"format": "png",
+		},
+	}
+	im, err := hdr.Handle(testdata.AdobeRGB, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(im.Data, []byte("iCCP")) {
+		t.Fatal("ICC profile found")
+	}
+	source, _, err := image.Decode(bytes.NewReader(testdata.AdobeRGB.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nim, _, err := image.Decode(bytes.NewReader(im.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Adobe RGB green is outside of the sRGB gamut, so the red channel is reduced.
+	r1, _, _, _ := source.At(32, 32).RGBA()
+	r2, _, _, _ := nim.At(32, 32).RGBA()
+	if int(r1)-int(r2) < 0x1000 {
+		t.Fatalf("the pixels are not converted: got red %#x, source red %#x", r2, r1)
+	}
+}
+
+func TestBuildStepVignette(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		handler            *Handler
+		params             imageserver.Params
+		expectedStep       bool
+		expectedParamError string
+	}{
+		{
+			name:   "Empty",
+			params: imageserver.Params{},
+		},
+		{
+			name:         "Vignette",
+			params:       imageserver.Params{"vignette": true},
+			expectedStep: true,
+		},
+		{
+			name:         "VignetteStrength",
+			params:       imageserver.Params{"vignette": true, "vignette_strength": 100},
+			expectedStep: true,
+		},
+		{
+			name:   "VignetteFalse",
+			params: imageserver.Params{"vignette": false},
+		},
+		{
+			name:               "VignetteInvalid",
+			params:             imageserver.Params{"vignette": "foo"},
+			expectedParamError: "vignette",
+		},
+		{
+			name:               "VignetteStrengthOutOfRange",
+			params:             imageserver.Params{"vignette": true, "vignette_strength": 101},
+			expectedParamError: "vignette_strength",
+		},
+		{
+			name:               "VignetteStrengthNegative",
+			params:             imageserver.Params{"vignette": true, "vignette_strength": -1},
+			expectedParamError: "vignette_strength",
+		},
+		{
+			name:               "VignetteGeometry",
+			params:             imageserver.Params{"vignette": "0x10+5+5"},
+			expectedParamError: "vignette",
+		},
+		{
+			name:    "ImageMagick",
+			handler: &Handler{Backend: ImageMagick},
+			params:  imageserver.Params{"vignette": true},
+		},
+		{
+			name:               "VignetteStrengthInvalid",
+			params:             imageserver.Params{"vignette": true, "vignette_strength": "foo"},
+			expectedParamError: "vignette_strength",
+		},
+		{
+			name:               "VignetteStrengthWithoutVignette",
+			params:             imageserver.Params{"vignette_strength": 50},
+			expectedParamError: "vignette_strength",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hdr := tc.handler
+			if hdr == nil {
+				hdr = &Handler{}
+			}
+			st, err := hdr.buildStepVignette(tc.params)
+			if err != nil {
+				if err, ok := err.(*imageserver.ParamError); ok && tc.expectedParamError == err.Param {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tc.expectedParamError != "" {
+				t.Fatal("no error")
+			}
+			if (st != nil) != tc.expectedStep {
+				t.Fatalf("unexpected step: got %t, want %t", st != nil, tc.expectedStep)
+			}
+		})
+	}
+}
+
+func TestBuildVignetteMaskArguments(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		width    int
+		height   int
+		strength int
+		expected []string
+	}{
+		{
+			name:     "Default",
+			width:    1024,
+			height:   819,
+			strength: 50,
+			expected: []string{"convert", "-size", "1024x819", "xc:#7f7f7f", "-fill", "white", "-draw", "ellipse 512,409 409,327 0,360", "-blur", "0x102", "mask"},
+		},
+		{
+			name:     "Strength",
+			width:    1024,
+			height:   819,
+			strength: 40,
+			expected: []string{"convert", "-size", "1024x819", "xc:#999999", "-fill", "white", "-draw", "ellipse 512,409 409,327 0,360", "-blur", "0x102", "mask"},
+		},
+		{
+			name:     "StrengthMax",
+			width:    100,
+			height:   200,
+			strength: 100,
+			expected: []string{"convert", "-size", "100x200", "xc:#000000", "-fill", "white", "-draw", "ellipse 50,100 40,80 0,360", "-blur", "0x12", "mask"},
+		},
+		{
+			name:     "Small",
+			width:    4,
+			height:   4,
+			strength: 0,
+			expected: []string{"convert", "-size", "4x4", "xc:#ffffff", "-fill", "white", "-draw", "ellipse 2,2 1,1 0,360", "-blur", "0x1", "mask"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			arguments := buildVignetteMaskArguments(tc.width, tc.height, tc.strength, "mask")
+			if !reflect.DeepEqual(arguments, tc.expected) {
+				t.Fatalf("unexpected arguments: got %q, want %q", arguments, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBuildStepWatermark(t *testing.T) {
+	watermarkServer := imageserver.ServerFunc(func(params imageserver.Params) (*imageserver.Image, error) {
+		return testdata.Small, nil
+	})
+	for _, tc := range []struct {
+		name               string
+		handler            *Handler
+		params             imageserver.Params
+		expectedStep       bool
+		expectedParamError string
+	}{
+		{
+			name:   "Empty",
+			params: imageserver.Params{},
+		},
+		{
+			name:         "Watermark",
+			params:       imageserver.Params{"watermark": imageserver.Params{"source": "logo.png"}},
+			expectedStep: true,
+		},
+		{
+			name: "WatermarkAll",
+			params: imageserver.Params{"watermark": imageserver.Params{
+				"source":   "logo.png",
+				"gravity":  "se",
+				"dissolve": 50,
+				"x":        10,
+				"y":        -10,
+			}},
+			expectedStep: true,
+		},
+		{
+			name:               "WatermarkNotSupported",
+			handler:            &Handler{},
+			params:             imageserver.Params{"watermark": imageserver.Params{"source": "logo.png"}},
+			expectedParamError: "watermark",
+		},
+		{
+			name:               "WatermarkInvalid",
+			params:             imageserver.Params{"watermark": "logo.png"},
+			expectedParamError: "watermark",
+		},
+		{
+			name:               "WatermarkSourceMissing",
+			params:             imageserver.Params{"watermark": imageserver.Params{}},
+			expectedParamError: "watermark.source",
+		},
+		{
+			name:               "WatermarkGravityInvalid",
+			params:             imageserver.Params{"watermark": imageserver.Params{"source": "logo.png", "gravity": "foo"}},
+			expectedParamError: "watermark.gravity",
+		},
+		{
+			name:               "WatermarkDissolveOutOfRange",
+			params:             imageserver.Params{"watermark": imageserver.Params{"source": "logo.png", "dissolve": 101}},
+			expectedParamError: "watermark.dissolve",
+		},
+		{
+			name:               "WatermarkXInvalid",
+			params:             imageserver.Params{"watermark": imageserver.Params{"source": "logo.png", "x": "10"}},
+			expectedParamError: "watermark.x",
+		},
+		{
+			name:               "WatermarkYInvalid",
+			params:             imageserver.Params{"watermark": imageserver.Params{"source": "logo.png", "y": 1.5}},
+			expectedParamError: "watermark.y",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hdr := tc.handler
+			if hdr == nil {
+				hdr = &Handler{WatermarkServer: watermarkServer}
+			}
+			st, err := hdr.buildStepWatermark(tc.params)
+			if err != nil {
+				if err, ok := err.(*imageserver.ParamError); ok && tc.expectedParamError == err.Param {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tc.expectedParamError != "" {
+				t.Fatal("no error")
+			}
+			if (st != nil) != tc.expectedStep {
+				t.Fatalf("unexpected step: got %t, want %t", st != nil, tc.expectedStep)
+			}
+		})
+	}
+}
+
+func TestBuildWatermarkArguments(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		watermark *watermark
+		expected  []string
+	}{
+		{
+			name:      "Default",
+			watermark: &watermark{gravity: "Center", dissolve: 100},
+			expected:  []string{"composite", "-gravity", "Center", "-geometry", "+0+0", "watermark", "image", "image"},
+		},
+		{
+			name:      "All",
+			watermark: &watermark{gravity: "SouthEast", dissolve: 50, x: 10, y: -20},
+			expected:  []string{"composite", "-gravity", "SouthEast", "-geometry", "+10-20", "-dissolve", "50", "watermark", "image", "image"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			arguments := buildWatermarkArguments(tc.watermark, "watermark", "image")
+			if !reflect.DeepEqual(arguments, tc.expected) {
+				t.Fatalf("unexpected arguments: got %v, want %v", arguments, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFormatStderr(t *testing.T) {
+	s := formatStderr([]byte(" foo\n"))
+	if s != "foo" {
+		t.Fatalf("unexpected result: %q", s)
+	}
+	s = formatStderr(bytes.Repeat([]byte("a"), maxStderrSize+1))
+	if s != strings.Repeat("a", maxStderrSize)+"... (truncated)" {
+		t.Fatalf("unexpected result: %q", s)
+	}
+}
+
+// testFakeExecutable creates a fake executable shell script, and returns its path and a cleanup func.
+func testFakeExecutable(tb testing.TB, script string) (file string, cleanup func()) {
+	if runtime.GOOS == "windows" {
+		tb.Skip("shell scripts are not supported on Windows")
+	}
+	dir, err := ioutil.TempDir("", "imageserver_graphicsmagick_test_")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cleanup = func() {
+		_ = os.RemoveAll(dir)
+	}
+	file = filepath.Join(dir, "gm")
+	err = ioutil.WriteFile(file, []byte("#!/bin/sh\n"+script+"\n"), os.FileMode(0700))
+	if err != nil {
+		cleanup()
+		tb.Fatal(err)
+	}
+	return file, cleanup
+}
+
+func testCheckAvailable(tb testing.TB) {
+	_, err := exec.LookPath(testExecutable)
+	if err != nil {
+		tb.Skipf("GraphicsMagick is not available: %s", err)
+	}
+}
+
+func testCheckAvailableImageMagick(tb testing.TB) {
+	_, err := exec.LookPath(testImageMagickExecutable)
+	if err != nil {
+		tb.Skipf("ImageMagick is not available: %s", err)
 	}
 }
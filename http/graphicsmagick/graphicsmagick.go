@@ -2,7 +2,9 @@
 package graphicsmagick
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/pierrre/imageserver"
@@ -11,6 +13,124 @@ import (
 
 const (
 	globalParam = "graphicsmagick"
+
+	watermarkParam = "watermark"
+)
+
+var (
+	intParams = []string{
+		"width",
+		"height",
+		"brightness",
+		"saturation",
+		"hue",
+		"median",
+		"oil_paint",
+		"posterize",
+		"resize_area",
+		"extent_x",
+		"extent_y",
+		"colors",
+		"threshold",
+		"black_threshold",
+		"white_threshold",
+		"vignette_strength",
+		"quality",
+		"max_bytes",
+		"png_compression",
+		"webp_alpha_quality",
+		"depth",
+	}
+	floatParams = []string{
+		"trim_fuzz",
+		"transparent_fuzz",
+		"crop_focus_x",
+		"crop_focus_y",
+		"dpr",
+		"rotate",
+		"resize_percent",
+		"charcoal",
+		"emboss",
+		"swirl",
+		"implode",
+	}
+	boolParams = []string{
+		"auto_orient",
+		"srgb",
+		"coalesce",
+		"trim",
+		"rotate_expand",
+		"transpose",
+		"transverse",
+		"fill",
+		"ignore_ratio",
+		"only_shrink_larger",
+		"only_enlarge_smaller",
+		"normalize",
+		"equalize",
+		"negate",
+		"negate_grays_only",
+		"flatten",
+		"extent",
+		"pad",
+		"strip",
+		"keep_icc",
+		"dither",
+		"webp_lossless",
+		"png8",
+	}
+	stringParams = []string{
+		"density",
+		"crop",
+		"crop_ratio",
+		"resize_mode",
+		"fit",
+		"filter",
+		"gaussian_blur",
+		"blur",
+		"unsharp",
+		"type",
+		"colorspace",
+		"level",
+		"contrast_stretch",
+		"modulate",
+		"gamma",
+		"sigmoidal_contrast",
+		"background",
+		"transparent",
+		"wave",
+		"roll",
+		"shave",
+		"chop",
+		"shear",
+		"gravity",
+		"border",
+		"border_color",
+		"bordercolor",
+		"keep_profiles",
+		"alpha",
+		"format",
+		"sampling_factor",
+	}
+	boolOrStringParams = []string{
+		"sharpen",
+		"interlace",
+		"vignette",
+	}
+	watermarkStringParams = []string{
+		"source",
+		"gravity",
+	}
+	watermarkIntParams = []string{
+		"dissolve",
+		"x",
+		"y",
+	}
+	intOrBoolParams = []string{
+		"despeckle",
+		"sepia",
+		"solarize",
+	}
 )
 
 // Parser is a imageserver/http.Parser implementation for imageserver/graphicsmagick.Handler.
@@ -19,6 +139,7 @@ const (
 // This Params is added to the given Params at the key "graphicsmagick".
 //
 // See imageserver/graphicsmagick.Handler for params list.
+// The "watermark" sub-params are taken from the "watermark.<name>" query params (e.g. "watermark.source").
 type Parser struct{}
 
 // Parse implements imageserver/http.Parser.
@@ -38,32 +159,95 @@ func (parser *Parser) Parse(req *http.Request, params imageserver.Params) error
 }
 
 func (parser *Parser) parse(req *http.Request, params imageserver.Params) error {
-	if err := imageserver_http.ParseQueryInt("width", req, params); err != nil {
-		return err
+	for _, p := range intParams {
+		if err := imageserver_http.ParseQueryInt(p, req, params); err != nil {
+			return err
+		}
 	}
-	if err := imageserver_http.ParseQueryInt("height", req, params); err != nil {
-		return err
+	for _, p := range floatParams {
+		if err := imageserver_http.ParseQueryFloat(p, req, params); err != nil {
+			return err
+		}
 	}
-	if err := imageserver_http.ParseQueryBool("fill", req, params); err != nil {
-		return err
+	for _, p := range boolParams {
+		if err := imageserver_http.ParseQueryBool(p, req, params); err != nil {
+			return err
+		}
 	}
-	if err := imageserver_http.ParseQueryBool("ignore_ratio", req, params); err != nil {
-		return err
+	for _, p := range stringParams {
+		imageserver_http.ParseQueryString(p, req, params)
 	}
-	if err := imageserver_http.ParseQueryBool("only_shrink_larger", req, params); err != nil {
-		return err
+	for _, p := range boolOrStringParams {
+		parseQueryBoolOrString(p, req, params)
 	}
-	if err := imageserver_http.ParseQueryBool("only_enlarge_smaller", req, params); err != nil {
-		return err
+	for _, p := range intOrBoolParams {
+		if err := parseQueryIntOrBool(p, req, params); err != nil {
+			return err
+		}
 	}
-	if err := imageserver_http.ParseQueryBool("extent", req, params); err != nil {
-		return err
+	return parseWatermark(req, params)
+}
+
+func parseWatermark(req *http.Request, params imageserver.Params) error {
+	query := req.URL.Query()
+	p := imageserver.Params{}
+	for _, name := range watermarkStringParams {
+		if s := query.Get(watermarkParam + "." + name); s != "" {
+			p.Set(name, s)
+		}
 	}
-	if err := imageserver_http.ParseQueryInt("quality", req, params); err != nil {
-		return err
+	for _, name := range watermarkIntParams {
+		s := query.Get(watermarkParam + "." + name)
+		if s == "" {
+			continue
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return &imageserver.ParamError{Param: watermarkParam + "." + name, Message: fmt.Sprintf("parse int: %s", err)}
+		}
+		p.Set(name, i)
+	}
+	if !p.Empty() {
+		params.Set(watermarkParam, p)
+	}
+	return nil
+}
+
+// parseQueryBoolOrString takes the param from the HTTP URL query and add it to the Params as a bool if it can be parsed, or as a string otherwise.
+func parseQueryBoolOrString(param string, req *http.Request, params imageserver.Params) {
+	s := req.URL.Query().Get(param)
+	if s == "" {
+		return
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		params.Set(param, b)
+		return
+	}
+	params.Set(param, s)
+}
+
+// parseQueryIntOrBool takes the param from the HTTP URL query and add it to the Params as a bool if it can be parsed (an empty value is true), or as an int otherwise.
+//
+// The bool is parsed first, so "1" and "0" are true and false.
+func parseQueryIntOrBool(param string, req *http.Request, params imageserver.Params) error {
+	values, ok := req.URL.Query()[param]
+	if !ok || len(values) == 0 {
+		return nil
+	}
+	s := values[0]
+	if s == "" {
+		params.Set(param, true)
+		return nil
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		params.Set(param, b)
+		return nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return &imageserver.ParamError{Param: param, Message: fmt.Sprintf("parse int or bool: %s", err)}
 	}
-	imageserver_http.ParseQueryString("background", req, params)
-	imageserver_http.ParseQueryString("format", req, params)
+	params.Set(param, i)
 	return nil
 }
 
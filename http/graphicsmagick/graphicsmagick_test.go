@@ -36,6 +36,51 @@ func TestParse(t *testing.T) {
 				"height": 100,
 			}},
 		},
+		{
+			name:  "AutoOrient",
+			query: url.Values{"auto_orient": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"auto_orient": true,
+			}},
+		},
+		{
+			name:  "Transpose",
+			query: url.Values{"transpose": {"true"}, "transverse": {"false"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"transpose":  true,
+				"transverse": false,
+			}},
+		},
+		{
+			name:  "Despeckle",
+			query: url.Values{"despeckle": {"2"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"despeckle": 2,
+			}},
+		},
+		{
+			name:  "Median",
+			query: url.Values{"median": {"3"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"median": 3,
+			}},
+		},
+		{
+			name:  "Posterize",
+			query: url.Values{"posterize": {"4"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"posterize": 4,
+			}},
+		},
+		{
+			name:  "Thresholds",
+			query: url.Values{"threshold": {"50"}, "black_threshold": {"10"}, "white_threshold": {"90"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"threshold":       50,
+				"black_threshold": 10,
+				"white_threshold": 90,
+			}},
+		},
 		{
 			name:  "Fill",
 			query: url.Values{"fill": {"true"}},
@@ -64,6 +109,348 @@ func TestParse(t *testing.T) {
 				"only_enlarge_smaller": true,
 			}},
 		},
+		{
+			name:  "GaussianBlur",
+			query: url.Values{"gaussian_blur": {"2x1.5"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"gaussian_blur": "2x1.5",
+			}},
+		},
+		{
+			name:  "Blur",
+			query: url.Values{"blur": {"0x3"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"blur": "0x3",
+			}},
+		},
+		{
+			name:  "SharpenBool",
+			query: url.Values{"sharpen": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sharpen": true,
+			}},
+		},
+		{
+			name:  "SharpenString",
+			query: url.Values{"sharpen": {"1x0.5"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sharpen": "1x0.5",
+			}},
+		},
+		{
+			name:  "Unsharp",
+			query: url.Values{"unsharp": {"1.5x1+0.8+0.05"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"unsharp": "1.5x1+0.8+0.05",
+			}},
+		},
+		{
+			name:  "Level",
+			query: url.Values{"level": {"5%,1.0,95%"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"level": "5%,1.0,95%",
+			}},
+		},
+		{
+			name:  "ContrastStretch",
+			query: url.Values{"contrast_stretch": {"2,1"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"contrast_stretch": "2,1",
+			}},
+		},
+		{
+			name:  "Modulate",
+			query: url.Values{"modulate": {"120,80,100"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"modulate": "120,80,100",
+			}},
+		},
+		{
+			name:  "Brightness",
+			query: url.Values{"brightness": {"120"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"brightness": 120,
+			}},
+		},
+		{
+			name:  "Saturation",
+			query: url.Values{"saturation": {"80"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"saturation": 80,
+			}},
+		},
+		{
+			name:  "Hue",
+			query: url.Values{"hue": {"150"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"hue": 150,
+			}},
+		},
+		{
+			name:  "Gamma",
+			query: url.Values{"gamma": {"2.2"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"gamma": "2.2",
+			}},
+		},
+		{
+			name:  "Colorspace",
+			query: url.Values{"colorspace": {"GRAY"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"colorspace": "GRAY",
+			}},
+		},
+		{
+			name:  "Normalize",
+			query: url.Values{"normalize": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"normalize": true,
+			}},
+		},
+		{
+			name:  "Equalize",
+			query: url.Values{"equalize": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"equalize": true,
+			}},
+		},
+		{
+			name:  "Negate",
+			query: url.Values{"negate": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"negate": true,
+			}},
+		},
+		{
+			name:  "NegateGraysOnly",
+			query: url.Values{"negate_grays_only": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"negate_grays_only": true,
+			}},
+		},
+		{
+			name:  "SepiaBool",
+			query: url.Values{"sepia": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sepia": true,
+			}},
+		},
+		{
+			name:  "SepiaEmpty",
+			query: url.Values{"sepia": {""}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sepia": true,
+			}},
+		},
+		{
+			name:  "SepiaOne",
+			query: url.Values{"sepia": {"1"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sepia": true,
+			}},
+		},
+		{
+			name:  "SepiaZero",
+			query: url.Values{"sepia": {"0"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sepia": false,
+			}},
+		},
+		{
+			name:  "SepiaInt",
+			query: url.Values{"sepia": {"60"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sepia": 60,
+			}},
+		},
+		{
+			name:  "Solarize",
+			query: url.Values{"solarize": {"40"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"solarize": 40,
+			}},
+		},
+		{
+			name:  "ResizeMode",
+			query: url.Values{"resize_mode": {"sample"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"resize_mode": "sample",
+			}},
+		},
+		{
+			name:  "Pad",
+			query: url.Values{"width": {"100"}, "height": {"100"}, "pad": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"width":  100,
+				"height": 100,
+				"pad":    true,
+			}},
+		},
+		{
+			name:  "ExtentOffset",
+			query: url.Values{"extent": {"true"}, "extent_x": {"10"}, "extent_y": {"-5"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"extent":   true,
+				"extent_x": 10,
+				"extent_y": -5,
+			}},
+		},
+		{
+			name:  "Trim",
+			query: url.Values{"trim": {"true"}, "trim_fuzz": {"10"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"trim":      true,
+				"trim_fuzz": 10.0,
+			}},
+		},
+		{
+			name:  "Transparent",
+			query: url.Values{"transparent": {"ffffff"}, "transparent_fuzz": {"5"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"transparent":      "ffffff",
+				"transparent_fuzz": 5.0,
+			}},
+		},
+		{
+			name:  "Type",
+			query: url.Values{"type": {"bilevel"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"type": "bilevel",
+			}},
+		},
+		{
+			name:  "SigmoidalContrast",
+			query: url.Values{"sigmoidal_contrast": {"3,50%"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sigmoidal_contrast": "3,50%",
+			}},
+		},
+		{
+			name:  "Alpha",
+			query: url.Values{"alpha": {"remove"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"alpha": "remove",
+			}},
+		},
+		{
+			name:  "SamplingFactor",
+			query: url.Values{"sampling_factor": {"4:2:0"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"sampling_factor": "4:2:0",
+			}},
+		},
+		{
+			name:  "Flatten",
+			query: url.Values{"flatten": {"true"}, "background": {"ffffff"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"flatten":    true,
+				"background": "ffffff",
+			}},
+		},
+		{
+			name:  "Coalesce",
+			query: url.Values{"coalesce": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"coalesce": true,
+			}},
+		},
+		{
+			name:  "Crop",
+			query: url.Values{"crop": {"50%,50%,25%,10"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"crop": "50%,50%,25%,10",
+			}},
+		},
+		{
+			name:  "CropRatio",
+			query: url.Values{"crop_ratio": {"16:9"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"crop_ratio": "16:9",
+			}},
+		},
+		{
+			name:  "CropFocus",
+			query: url.Values{"crop_ratio": {"1:1"}, "crop_focus_x": {"25.5"}, "crop_focus_y": {"10"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"crop_ratio":   "1:1",
+				"crop_focus_x": 25.5,
+				"crop_focus_y": 10.0,
+			}},
+		},
+		{
+			name:  "Fit",
+			query: url.Values{"width": {"200"}, "height": {"100"}, "fit": {"cover"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"width":  200,
+				"height": 100,
+				"fit":    "cover",
+			}},
+		},
+		{
+			name:  "Density",
+			query: url.Values{"density": {"300x300"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"density": "300x300",
+			}},
+		},
+		{
+			name:  "Filter",
+			query: url.Values{"filter": {"lanczos"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"filter": "lanczos",
+			}},
+		},
+		{
+			name:  "ResizeArea",
+			query: url.Values{"resize_area": {"250000"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"resize_area": 250000,
+			}},
+		},
+		{
+			name:  "DPR",
+			query: url.Values{"width": {"200"}, "dpr": {"2"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"width": 200,
+				"dpr":   2.0,
+			}},
+		},
+		{
+			name:  "Rotate",
+			query: url.Values{"rotate": {"-12.5"}, "rotate_expand": {"false"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"rotate":        -12.5,
+				"rotate_expand": false,
+			}},
+		},
+		{
+			name:  "ResizePercent",
+			query: url.Values{"resize_percent": {"50"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"resize_percent": 50.0,
+			}},
+		},
+		{
+			name:  "Charcoal",
+			query: url.Values{"charcoal": {"1.5"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"charcoal": 1.5,
+			}},
+		},
+		{
+			name:  "Emboss",
+			query: url.Values{"emboss": {"2"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"emboss": 2.0,
+			}},
+		},
+		{
+			name:  "OilPaint",
+			query: url.Values{"oil_paint": {"3"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"oil_paint": 3,
+			}},
+		},
 		{
 			name:  "Background",
 			query: url.Values{"background": {"123abc"}},
@@ -71,6 +458,48 @@ func TestParse(t *testing.T) {
 				"background": "123abc",
 			}},
 		},
+		{
+			name:  "Distortions",
+			query: url.Values{"swirl": {"90"}, "implode": {"-0.5"}, "wave": {"10,50"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"swirl":   90.0,
+				"implode": -0.5,
+				"wave":    "10,50",
+			}},
+		},
+		{
+			name:  "Roll",
+			query: url.Values{"roll": {"+10-20"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"roll": "+10-20",
+			}},
+		},
+		{
+			name:  "Shear",
+			query: url.Values{"shear": {"10,-5"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"shear": "10,-5",
+			}},
+		},
+		{
+			name: "Watermark",
+			query: url.Values{
+				"watermark.source":   {"logo.png"},
+				"watermark.gravity":  {"se"},
+				"watermark.dissolve": {"50"},
+				"watermark.x":        {"10"},
+				"watermark.y":        {"-10"},
+			},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"watermark": imageserver.Params{
+					"source":   "logo.png",
+					"gravity":  "se",
+					"dissolve": 50,
+					"x":        10,
+					"y":        -10,
+				},
+			}},
+		},
 		{
 			name:  "Extent",
 			query: url.Values{"extent": {"true"}},
@@ -78,6 +507,88 @@ func TestParse(t *testing.T) {
 				"extent": true,
 			}},
 		},
+		{
+			name:  "Gravity",
+			query: url.Values{"gravity": {"NorthEast"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"gravity": "NorthEast",
+			}},
+		},
+		{
+			name:  "Border",
+			query: url.Values{"border": {"2x4"}, "border_color": {"000"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"border":       "2x4",
+				"border_color": "000",
+			}},
+		},
+		{
+			name:  "BorderColorAlias",
+			query: url.Values{"border": {"2"}, "bordercolor": {"f00"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"border":      "2",
+				"bordercolor": "f00",
+			}},
+		},
+		{
+			name:  "Shave",
+			query: url.Values{"shave": {"10x5"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"shave": "10x5",
+			}},
+		},
+		{
+			name:  "Chop",
+			query: url.Values{"chop": {"10x0+50+0"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"chop": "10x0+50+0",
+			}},
+		},
+		{
+			name:  "Vignette",
+			query: url.Values{"vignette": {"true"}, "vignette_strength": {"40"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"vignette":          true,
+				"vignette_strength": 40,
+			}},
+		},
+		{
+			name:  "VignetteGeometry",
+			query: url.Values{"vignette": {"0x10+5+5"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"vignette": "0x10+5+5",
+			}},
+		},
+		{
+			name:  "Colors",
+			query: url.Values{"colors": {"16"}, "dither": {"false"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"colors": 16,
+				"dither": false,
+			}},
+		},
+		{
+			name:  "KeepProfiles",
+			query: url.Values{"strip": {"true"}, "keep_profiles": {"icc,xmp"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"strip":         true,
+				"keep_profiles": "icc,xmp",
+			}},
+		},
+		{
+			name:  "Strip",
+			query: url.Values{"strip": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"strip": true,
+			}},
+		},
+		{
+			name:  "KeepICC",
+			query: url.Values{"keep_icc": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"keep_icc": true,
+			}},
+		},
 		{
 			name:  "Format",
 			query: url.Values{"format": {"jpeg"}},
@@ -92,6 +603,65 @@ func TestParse(t *testing.T) {
 				"quality": 75,
 			}},
 		},
+		{
+			name:  "MaxBytes",
+			query: url.Values{"max_bytes": {"51200"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"max_bytes": 51200,
+			}},
+		},
+		{
+			name:  "PNGCompression",
+			query: url.Values{"png_compression": {"9"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"png_compression": 9,
+			}},
+		},
+		{
+			name:  "SRGB",
+			query: url.Values{"srgb": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"srgb": true,
+			}},
+		},
+		{
+			name:  "PNG8",
+			query: url.Values{"format": {"png"}, "png8": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"format": "png",
+				"png8":   true,
+			}},
+		},
+		{
+			name:  "WebP",
+			query: url.Values{"format": {"webp"}, "webp_lossless": {"true"}, "webp_alpha_quality": {"50"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"format":             "webp",
+				"webp_lossless":      true,
+				"webp_alpha_quality": 50,
+			}},
+		},
+		{
+			name:  "Depth",
+			query: url.Values{"depth": {"8"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"depth": 8,
+			}},
+		},
+		{
+			name:  "Interlace",
+			query: url.Values{"interlace": {"true"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"interlace": true,
+			}},
+		},
+		{
+			name:  "InterlaceString",
+			query: url.Values{"interlace": {"Plane"}},
+			expectedParams: imageserver.Params{globalParam: imageserver.Params{
+				"interlace": "Plane",
+			}},
+		},
 		{
 			name:               "WidthInvalid",
 			query:              url.Values{"width": {"invalid"}},
@@ -102,6 +672,41 @@ func TestParse(t *testing.T) {
 			query:              url.Values{"height": {"invalid"}},
 			expectedParamError: globalParam + ".height",
 		},
+		{
+			name:               "TransposeInvalid",
+			query:              url.Values{"transpose": {"invalid"}},
+			expectedParamError: globalParam + ".transpose",
+		},
+		{
+			name:               "AutoOrientInvalid",
+			query:              url.Values{"auto_orient": {"invalid"}},
+			expectedParamError: globalParam + ".auto_orient",
+		},
+		{
+			name:               "BrightnessInvalid",
+			query:              url.Values{"brightness": {"invalid"}},
+			expectedParamError: globalParam + ".brightness",
+		},
+		{
+			name:               "MedianInvalid",
+			query:              url.Values{"median": {"invalid"}},
+			expectedParamError: globalParam + ".median",
+		},
+		{
+			name:               "PosterizeInvalid",
+			query:              url.Values{"posterize": {"4.5"}},
+			expectedParamError: globalParam + ".posterize",
+		},
+		{
+			name:               "ThresholdInvalid",
+			query:              url.Values{"threshold": {"50%"}},
+			expectedParamError: globalParam + ".threshold",
+		},
+		{
+			name:               "OilPaintInvalid",
+			query:              url.Values{"oil_paint": {"invalid"}},
+			expectedParamError: globalParam + ".oil_paint",
+		},
 		{
 			name:               "FillInvalid",
 			query:              url.Values{"fill": {"invalid"}},
@@ -122,16 +727,111 @@ func TestParse(t *testing.T) {
 			query:              url.Values{"only_enlarge_smaller": {"invalid"}},
 			expectedParamError: globalParam + ".only_enlarge_smaller",
 		},
+		{
+			name:               "NormalizeInvalid",
+			query:              url.Values{"normalize": {"invalid"}},
+			expectedParamError: globalParam + ".normalize",
+		},
+		{
+			name:               "EqualizeInvalid",
+			query:              url.Values{"equalize": {"invalid"}},
+			expectedParamError: globalParam + ".equalize",
+		},
+		{
+			name:               "NegateInvalid",
+			query:              url.Values{"negate": {"banana"}},
+			expectedParamError: globalParam + ".negate",
+		},
+		{
+			name:               "NegateGraysOnlyInvalid",
+			query:              url.Values{"negate_grays_only": {"banana"}},
+			expectedParamError: globalParam + ".negate_grays_only",
+		},
+		{
+			name:               "SepiaInvalid",
+			query:              url.Values{"sepia": {"invalid"}},
+			expectedParamError: globalParam + ".sepia",
+		},
+		{
+			name:               "SolarizeInvalid",
+			query:              url.Values{"solarize": {"invalid"}},
+			expectedParamError: globalParam + ".solarize",
+		},
+		{
+			name:               "RotateInvalid",
+			query:              url.Values{"rotate": {"abc"}},
+			expectedParamError: globalParam + ".rotate",
+		},
+		{
+			name:               "DPRInvalid",
+			query:              url.Values{"dpr": {"2x"}},
+			expectedParamError: globalParam + ".dpr",
+		},
+		{
+			name:               "ResizePercentInvalid",
+			query:              url.Values{"resize_percent": {"50%"}},
+			expectedParamError: globalParam + ".resize_percent",
+		},
+		{
+			name:               "SwirlInvalid",
+			query:              url.Values{"swirl": {"invalid"}},
+			expectedParamError: globalParam + ".swirl",
+		},
+		{
+			name:               "ImplodeInvalid",
+			query:              url.Values{"implode": {"invalid"}},
+			expectedParamError: globalParam + ".implode",
+		},
+		{
+			name:               "CharcoalInvalid",
+			query:              url.Values{"charcoal": {"invalid"}},
+			expectedParamError: globalParam + ".charcoal",
+		},
+		{
+			name:               "EmbossInvalid",
+			query:              url.Values{"emboss": {"invalid"}},
+			expectedParamError: globalParam + ".emboss",
+		},
 		{
 			name:               "ExtentInvalid",
 			query:              url.Values{"extent": {"invalid"}},
 			expectedParamError: globalParam + ".extent",
 		},
+		{
+			name:               "VignetteStrengthInvalid",
+			query:              url.Values{"vignette_strength": {"invalid"}},
+			expectedParamError: globalParam + ".vignette_strength",
+		},
+		{
+			name:               "ColorsInvalid",
+			query:              url.Values{"colors": {"invalid"}},
+			expectedParamError: globalParam + ".colors",
+		},
+		{
+			name:               "DitherInvalid",
+			query:              url.Values{"dither": {"invalid"}},
+			expectedParamError: globalParam + ".dither",
+		},
+		{
+			name:               "StripInvalid",
+			query:              url.Values{"strip": {"invalid"}},
+			expectedParamError: globalParam + ".strip",
+		},
+		{
+			name:               "WatermarkDissolveInvalid",
+			query:              url.Values{"watermark.source": {"logo.png"}, "watermark.dissolve": {"invalid"}},
+			expectedParamError: globalParam + ".watermark.dissolve",
+		},
 		{
 			name:               "QualityInvalid",
 			query:              url.Values{"quality": {"invalid"}},
 			expectedParamError: globalParam + ".quality",
 		},
+		{
+			name:               "DepthInvalid",
+			query:              url.Values{"depth": {"invalid"}},
+			expectedParamError: globalParam + ".depth",
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			u := &url.URL{
@@ -73,6 +73,11 @@ var (
 	// Random is a random Image.
 	Random = loadImage(RandomFileName, "png")
 
+	// AdobeRGBFileName is the file name of AdobeRGB.
+	AdobeRGBFileName = "adobe_rgb.jpg"
+	// AdobeRGB is a solid color Image with an embedded Adobe RGB (1998) ICC profile.
+	AdobeRGB = loadImage(AdobeRGBFileName, "jpeg")
+
 	// InvalidFileName is the file name of Invalid.
 	InvalidFileName = "invalid.jpg"
 	// Invalid is an invalid Image.